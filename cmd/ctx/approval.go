@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tmc/ctx/ctxrun"
+)
+
+// approverFor returns the ctxrun.Approver for an --approve policy.
+func approverFor(policy string) (ctxrun.Approver, error) {
+	switch strings.ToLower(policy) {
+	case "prompt", "":
+		return ctxrun.ApproverFunc(promptApproval), nil
+	case "always":
+		return ctxrun.ApproveAll, nil
+	case "never":
+		return ctxrun.DenyAll, nil
+	}
+	return nil, fmt.Errorf("invalid --approve policy %q (want prompt, always or never)", policy)
+}
+
+// promptApproval asks the user on the controlling terminal whether a plugin
+// may run. It denies the request when no terminal is available.
+func promptApproval(ctx context.Context, req ctxrun.ApprovalRequest) (bool, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		log.Printf("[%s] No terminal available for approval prompt; denying (use --approve=always to allow).", filepath.Base(req.Path))
+		return false, nil
+	}
+	defer tty.Close()
+
+	fmt.Fprintf(tty, "Plugin %q (%s, version %s) requests approval", req.Name, req.Path, req.Version)
+	if req.Message != "" {
+		fmt.Fprintf(tty, ":\n  %s", req.Message)
+	} else {
+		fmt.Fprintf(tty, ":\n  %s", req.Details)
+	}
+	fmt.Fprint(tty, "\nApprove? [y/N]: ")
+
+	line, err := bufio.NewReader(tty).ReadString('\n')
+	if err != nil && line == "" {
+		return false, nil
+	}
+	answer := strings.ToLower(strings.TrimSpace(line))
+	return answer == "y" || answer == "yes", nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/tmc/ctx/ctxrun"
+)
+
+// ctxDataSchema describes the data field printed by runAsPlugin.
+const ctxDataSchema = `{
+  "type": "object",
+  "properties": {
+    "description": {"type": "string"},
+    "environment": {"type": "object", "additionalProperties": {"type": "string"}}
+  },
+  "required": ["description", "environment"]
+}`
+
+// printCtxSpec answers --ctx-spec for ctx itself, describing what runAsPlugin outputs.
+func printCtxSpec() error {
+	spec := ctxrun.Capabilities{
+		CtxSpec:     ctxrun.SpecVersion,
+		Name:        "ctx",
+		Version:     getVersion(),
+		Description: "Core ctx metadata: the CTX_* environment ctx was invoked with",
+		SupportedEnv: []string{
+			"CTX_SESSION", "CTX_SHLVL", "CTX_CACHE_DIR", "CTX_OUTPUT_TOKEN_BUDGET",
+			"CTX_THINKING_TOKEN_BUDGET", "CTX_COST_BUDGET_CENTS", "CTX_ALLOWED_TOOLS",
+			"CTX_TIMEOUT_SECONDS", "CTX_DEADLINE_TIMESTAMP", "CTX_RETRY_MAX", "CTX_SHOW_SOURCE",
+		},
+		DataSchema: json.RawMessage(ctxDataSchema),
+	}
+	jsonBytes, err := json.Marshal(spec)
+	if err != nil {
+		return fmt.Errorf("failed to marshal capability document: %w", err)
+	}
+	fmt.Println(string(jsonBytes))
+	return nil
+}
+
+// printCapabilities prints a plugin's capability document for --list-plugins -v.
+func printCapabilities(runner *ctxrun.Runner, pluginPath string) {
+	caps, err := runner.Capabilities(context.Background(), pluginPath)
+	switch {
+	case err != nil:
+		fmt.Printf("      (capabilities unavailable: %v)\n", err)
+		return
+	case caps == nil:
+		fmt.Printf("      (no %s support)\n", ctxrun.SpecFlag)
+		return
+	}
+	fmt.Printf("      name: %s  version: %s\n", caps.Name, caps.Version)
+	if caps.Description != "" {
+		fmt.Printf("      description: %s\n", caps.Description)
+	}
+	printList := func(label string, items []string) {
+		if len(items) > 0 {
+			fmt.Printf("      %s: %s\n", label, strings.Join(items, ", "))
+		}
+	}
+	printList("modes", caps.Modes)
+	printList("tags", caps.Tags)
+	printList("supported env", caps.SupportedEnv)
+	printList("dependencies", caps.Dependencies)
+	if len(caps.DataSchema) > 0 {
+		fmt.Printf("      data schema: yes\n")
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io/fs"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/tmc/ctx/ctxrun"
+)
+
+// loadConfig merges the user config file (--config, by default
+// $XDG_CONFIG_HOME/ctx/config.yaml) and the nearest project-local .ctx.yaml,
+// in that order, and applies the result to every setting not given on the
+// command line. Missing default files are ignored. Per-plugin env is only
+// accepted from the user config.
+func loadConfig(cfg *config) (*ctxrun.Config, error) {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	merged := &ctxrun.Config{}
+	paths := []string{cfg.configFile}
+	if wd, err := os.Getwd(); err == nil {
+		paths = append(paths, ctxrun.FindProjectConfig(wd))
+	}
+	for i, path := range paths {
+		if path == "" {
+			continue
+		}
+		load := ctxrun.LoadConfig
+		if i > 0 {
+			load = ctxrun.LoadProjectConfig
+		}
+		c, err := load(path)
+		if errors.Is(err, fs.ErrNotExist) && !set["config"] {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Loaded config file %s", path)
+		merged.Merge(c)
+	}
+
+	str := func(dst *string, name, v string) {
+		if v != "" && !set[name] {
+			*dst = v
+		}
+	}
+	num := func(dst *int, name string, v int) {
+		if v != 0 && !set[name] {
+			*dst = v
+		}
+	}
+	dur := func(dst *time.Duration, name string, v ctxrun.Duration) {
+		if v != 0 && !set[name] {
+			*dst = time.Duration(v)
+		}
+	}
+	list := func(dst *string, name string, v []string) {
+		if v != nil && !set[name] {
+			*dst = strings.Join(v, ",")
+		}
+	}
+	str(&cfg.outputFormat, "output", merged.Output)
+	num(&cfg.indent, "indent", merged.Indent)
+	if !set["P"] {
+		num(&cfg.maxParallelPlugins, "parallel", merged.Parallel)
+	}
+	num(&cfg.outputTokenBudget, "output-token-budget", merged.OutputTokenBudget)
+	num(&cfg.thinkingTokenBudget, "thinking-token-budget", merged.ThinkingTokenBudget)
+	num(&cfg.costBudgetCents, "cost-budget", merged.CostBudgetCents)
+	str(&cfg.budgetPolicy, "budget-policy", merged.BudgetPolicy)
+	list(&cfg.priorities, "priority", merged.Priority)
+	str(&cfg.sortOrder, "sort", merged.Sort)
+	str(&cfg.duplicates, "duplicates", merged.Duplicates)
+	list(&cfg.allowedTools, "allowed-tools", merged.AllowedTools)
+	dur(&cfg.pluginTimeout, "plugin-timeout", merged.PluginTimeout)
+	dur(&cfg.globalTimeout, "timeout", merged.Timeout)
+	num(&cfg.pluginRetries, "plugin-retries", merged.PluginRetries)
+	if len(cfg.selectors) == 0 {
+		list(&cfg.only, "only", merged.Only)
+	}
+	list(&cfg.skip, "skip", merged.Skip)
+	return merged, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// parseArgs parses args as the ctx command line with a fresh flag set.
+func parseArgs(t *testing.T, command string, args ...string) *config {
+	t.Helper()
+	oldArgs, oldFlags := os.Args, flag.CommandLine
+	t.Cleanup(func() { os.Args, flag.CommandLine = oldArgs, oldFlags })
+	os.Args = append([]string{"ctx"}, args...)
+	flag.CommandLine = flag.NewFlagSet("ctx", flag.ContinueOnError)
+	return parseFlags(command)
+}
+
+// chdir changes the working directory for the rest of the test.
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	userConfig := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(userConfig, []byte(`
+output: json
+indent: 4
+parallel: 3
+budget_policy: drop
+only: [git]
+plugins:
+  git:
+    env: {GIT_DIR: /repo/.git}
+    timeout: 1s
+`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	project := filepath.Join(dir, "project")
+	if err := os.MkdirAll(filepath.Join(project, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(project, ".ctx.yaml"), []byte(`
+output: xml
+indent: 8
+only: [env]
+plugins:
+  git:
+    timeout: 1m
+`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	chdir(t, filepath.Join(project, "sub"))
+
+	cfg := parseArgs(t, "", "--config", userConfig, "--indent", "1", "-P", "2")
+	merged, err := loadConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.outputFormat != "xml" {
+		t.Errorf("output = %q, want the project's xml over the user's json", cfg.outputFormat)
+	}
+	if cfg.indent != 1 || cfg.maxParallelPlugins != 2 {
+		t.Errorf("indent %d, parallel %d; want the flags' 1 and 2", cfg.indent, cfg.maxParallelPlugins)
+	}
+	if cfg.budgetPolicy != "drop" {
+		t.Errorf("budget policy = %q, want the user's drop", cfg.budgetPolicy)
+	}
+	if cfg.only != "env" {
+		t.Errorf("only = %q, want the project's env", cfg.only)
+	}
+	git := merged.Plugins["git"]
+	if git.Env["GIT_DIR"] != "/repo/.git" || time.Duration(git.Timeout) != time.Minute {
+		t.Errorf("git plugin config = %+v, want the user's env and the project's timeout", git)
+	}
+
+	// Positional selectors replace configured ones.
+	cfg = parseArgs(t, "", "--config", userConfig, "git")
+	if _, err := loadConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.only != "" || cfg.outputFormat != "xml" {
+		t.Errorf("only %q, output %q; want no --only and xml", cfg.only, cfg.outputFormat)
+	}
+}
+
+func TestLoadConfigRejectsProjectEnv(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, ".ctx.yaml"), []byte("plugins:\n  git:\n    env: {PATH: /tmp/evil}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	chdir(t, dir)
+	cfg := parseArgs(t, "", "--config", filepath.Join(dir, "missing.yaml"))
+	cfg.configFile = "" // No user config
+	if _, err := loadConfig(cfg); err == nil || !strings.Contains(err.Error(), "env may only be set in the user config") {
+		t.Errorf("loadConfig error = %v, want project env rejected", err)
+	}
+}
+
+func TestLoadConfigMissingFiles(t *testing.T) {
+	dir := t.TempDir()
+	chdir(t, dir)
+	cfg := parseArgs(t, "")
+	cfg.configFile = filepath.Join(dir, "missing.yaml") // As if it were the default
+	if _, err := loadConfig(cfg); err != nil {
+		t.Errorf("loadConfig with a missing default config: %v", err)
+	}
+	cfg = parseArgs(t, "", "--config", filepath.Join(dir, "missing.yaml"))
+	if _, err := loadConfig(cfg); err == nil {
+		t.Error("loadConfig with a missing --config succeeded, want error")
+	}
+}
@@ -1,80 +1,84 @@
+// Command ctx gathers context by running ctx-* plugins and aggregating their
+// output. The plugin runner itself lives in package ctxrun.
 package main
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
-	"encoding/xml"
-	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
-	"path/filepath"
-	"runtime"
 	"runtime/debug" // For build info
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
+	"github.com/tmc/ctx/ctxrun"
 	"github.com/tmc/ctx/docs"
-	"sigs.k8s.io/yaml"
 )
 
-const ctxSessionEnvKey = "CTX_SESSION"
-const ctxShlvlEnvKey = "CTX_SHLVL"
-const ctxShowSourceEnvKey = "CTX_SHOW_SOURCE" // Always implies txtar format
-
-// Environment variables to propagate if set in ctx's environment
-var ambientEnvKeysToPropagate = []string{
-	"TRACEPARENT", // OpenTelemetry Trace Context
-	"TRACESTATE",  // OpenTelemetry Trace Context
-	// Add other standard ambient variables here as needed
-}
-
 // Configuration struct to hold parsed flags
 type config struct {
 	outputFormat        string
+	stream              bool // Write NDJSON as plugins finish (--output ndjson)
 	listPlugins         bool
 	showVersion         bool
 	printSpec           bool
 	actAsPlugin         bool // Act as a ctx-* plugin itself
+	printCtxSpec        bool // Print ctx's own capability document (--ctx-spec)
+	queryCapabilities   bool // Query plugins with --ctx-spec before running them
+	mode                string
 	cacheDir            string
 	outputTokenBudget   int
 	thinkingTokenBudget int
 	costBudgetCents     int
 	allowedTools        string
-	pluginTimeout       time.Duration
+	pluginTimeout       time.Duration // Per-plugin deadline
+	globalTimeout       time.Duration // Wall-clock cap on the whole run
+	killGrace           time.Duration // Time between SIGTERM and SIGKILL for timed-out plugins
 	pluginRetries       int
+	retryBackoff        time.Duration
+	retryExitCodes      string // Comma-separated exit statuses treated as transient
 	indent              int
 	summary             bool
-	maxParallelPlugins  int  // Maximum number of plugins to run in parallel
-	printSource         bool // Print plugin source when available (always in txtar format)
-	verbose             bool // Enable verbose logging
+	maxParallelPlugins  int                            // Maximum number of plugins to run in parallel
+	printSource         bool                           // Print plugin source when available (always in txtar format)
+	verbose             bool                           // Enable verbose logging
+	logDir              string                         // Per-session plugin stderr logs
+	logKeep             int                            // Session logs kept in logDir
+	embedStderr         int                            // Bytes of plugin stderr to include in output
+	usage               bool                           // Print aggregate plugin metrics to stderr
+	strict              bool                           // Reject plugin data violating its declared schema
+	budgetPolicy        string                         // none, truncate, drop or fail
+	priorities          string                         // Comma-separated plugin names, highest priority first
+	sortOrder           string                         // Plugin order in output: name or priority
+	xmlData             string                         // XML plugin data: structured or json
+	duplicates          string                         // Duplicate plugin name policy: first, namespace or error
+	only                string                         // Comma-separated plugin selectors to run
+	skip                string                         // Comma-separated plugin selectors to exclude
+	selectors           []string                       // Positional arguments, added to only
+	configFile          string                         // User config file; .ctx.yaml is merged over it
+	pluginConfig        map[string]ctxrun.PluginConfig // Per-plugin settings from config files
+	pluginDirs          listFlag                       // Extra plugin directories, highest precedence first
+	noCache             bool                           // Disable the host-side result cache
+	refreshCache        bool                           // Re-run cacheable plugins and refresh their cache entries
+	failOnError         bool                           // Exit non-zero if any plugin fails
+	approve             string                         // Approval policy: prompt, always or never
+	trustFile           string                         // Plugin SHA256 allowlist
+	trustPolicy         string                         // off, warn or enforce; empty means enforce if trustFile exists
+	command             string                         // Subcommand: "", watch, serve or mcp
+	watchInterval       time.Duration                  // Re-run interval for ctx watch; 0 disables
+	watchDir            string                         // Directory whose changes trigger ctx watch; empty disables
+	watchPoll           time.Duration                  // Scan interval where file notifications are unavailable
+	socket              string                         // Unix socket ctx serve listens on
+	maxAge              time.Duration                  // How long ctx serve reuses plugin results
+	remote              string                         // Socket of a ctx serve daemon to query instead of running plugins
 }
 
-// PluginData defines the expected JSON structure from plugins.
-type PluginData struct {
-	Name    string          `json:"name"`
-	Version string          `json:"version"`
-	Data    json.RawMessage `json:"data"` // Keep data as raw JSON initially
-}
-
-// XML structure for aggregated output
-type XMLResults struct {
-	XMLName   xml.Name    `xml:"ctx_results"`
-	SessionID string      `xml:"session_id,attr"`
-	Plugins   []XMLPlugin `xml:"plugin"`
-}
-
-type XMLPlugin struct {
-	Name    string `xml:"name,attr"`
-	Version string `xml:"version,attr,omitempty"`
-	// Embed data as marshaled JSON within a CDATA section or similar
-	Data xml.CharData `xml:"data"`
-}
+// exitPluginFailure is the exit status used with --fail-on-error when at
+// least one plugin failed. Output is still written before exiting.
+const exitPluginFailure = 3
 
 // handleHelpFlag checks if -h, -help, or --help flags are present and exits with code 1
 func handleHelpFlag() {
@@ -97,30 +101,30 @@ func runAsPlugin() error {
 			env[parts[0]] = parts[1]
 		}
 	}
-	
+
 	// Create a simple data structure
 	data := map[string]interface{}{
 		"environment": env,
 		"description": "Core ctx metadata",
 	}
-	
+
 	dataBytes, err := json.Marshal(data)
 	if err != nil {
 		return fmt.Errorf("failed to marshal plugin metadata: %w", err)
 	}
-	
+
 	// Base plugin response
-	pluginData := PluginData{
+	pluginData := ctxrun.PluginData{
 		Name:    "ctx",
 		Version: getVersion(),
 		Data:    dataBytes,
 	}
-	
+
 	jsonBytes, err := json.Marshal(pluginData)
 	if err != nil {
 		return fmt.Errorf("failed to marshal plugin data: %w", err)
 	}
-	
+
 	fmt.Println(string(jsonBytes))
 	return nil
 }
@@ -142,11 +146,25 @@ func main() {
 	// Set up custom logger that respects verbose mode
 	log.SetFlags(0)
 	log.SetOutput(&verboseLogger{})
-	
+
+	if len(os.Args) > 1 && os.Args[1] == "trust" {
+		if err := runTrust(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "ctx trust: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	command := "" // Subcommand taking the usual flags
+	if len(os.Args) > 1 && (os.Args[1] == "watch" || os.Args[1] == "serve" || os.Args[1] == "mcp") {
+		command = os.Args[1]
+		os.Args = append(os.Args[:1:1], os.Args[2:]...)
+	}
+
 	// Check for help flag first (for plugin spec compliance)
 	handleHelpFlag()
-	
-	cfg := parseFlags()
+
+	cfg := parseFlags(command)
 	verbose = cfg.verbose
 
 	if cfg.showVersion {
@@ -158,53 +176,144 @@ func main() {
 		// Print the embedded spec content (loaded during init) and exit
 		specContent, err := docs.All.ReadFile("PLUGIN_SPEC.md")
 		if err != nil {
-			log.Fatalf("Error reading embedded spec file: %v", err)
+			fmt.Fprintf(os.Stderr, "Error reading embedded spec file: %v\n", err)
+			os.Exit(1)
 		}
 		fmt.Println(string(specContent))
 		os.Exit(0)
 	}
-	
+
+	if cfg.printCtxSpec {
+		if err := printCtxSpec(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error printing capability document: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	if cfg.actAsPlugin {
 		if err := runAsPlugin(); err != nil {
-			log.Fatalf("Error running as plugin: %v", err)
+			fmt.Fprintf(os.Stderr, "Error running as plugin: %v\n", err)
+			os.Exit(1)
 		}
 		return
 	}
 
-	if err := run(cfg); err != nil {
-		log.Fatalf("Error: %v", err)
+	fileConfig, err := loadConfig(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	cfg.pluginConfig = fileConfig.Plugins
+
+	switch cfg.command {
+	case "watch":
+		err = runWatch(cfg)
+	case "serve":
+		err = runServe(cfg)
+	case "mcp":
+		err = runMCP(cfg)
+	default:
+		err = run(cfg)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
 	}
 }
 
-func parseFlags() *config {
+func parseFlags(command string) *config {
 	// Initialize with defaults
 	cfg := &config{
+		killGrace:          ctxrun.DefaultKillGrace,
+		retryBackoff:       ctxrun.DefaultRetryBackoff,
+		retryExitCodes:     "75",
 		outputFormat:       "yaml",
 		maxParallelPlugins: 1,
 		indent:             2,
+		approve:            "prompt",
+		budgetPolicy:       string(ctxrun.BudgetNone),
+		sortOrder:          "name",
+		xmlData:            ctxrun.XMLDataStructured,
+		duplicates:         string(ctxrun.DuplicateFirst),
+		trustFile:          ctxrun.DefaultTrustFilePath(),
+		configFile:         ctxrun.DefaultConfigPath(),
 	}
-	
+
 	// Define CLI flags
-	flag.StringVar(&cfg.outputFormat, "output", cfg.outputFormat, "Output format (yaml, json, xml)")
+	flag.StringVar(&cfg.configFile, "config", cfg.configFile, "YAML config file with defaults for these flags and per-plugin settings; a project-local .ctx.yaml is merged over it, and flags take precedence")
+	flag.Var(&cfg.pluginDirs, "plugin-dir", "Directory to search for ctx-* plugins before CTX_PLUGIN_PATH, PATH and .ctx/plugins (repeatable)")
+	flag.StringVar(&cfg.outputFormat, "output", cfg.outputFormat, "Output format (yaml, json, xml, markdown, prompt, txtar, ndjson)")
+	flag.BoolVar(&cfg.stream, "stream", cfg.stream, "Write each plugin's result as a JSON line as soon as it finishes, then a summary line (same as --output ndjson)")
 	flag.BoolVar(&cfg.listPlugins, "list-plugins", cfg.listPlugins, "List discovered plugins and exit")
 	flag.BoolVar(&cfg.showVersion, "version", cfg.showVersion, "Show version and build information")
 	flag.BoolVar(&cfg.printSpec, "print-spec", cfg.printSpec, "Print the plugin specification to stdout and exit")
 	flag.BoolVar(&cfg.actAsPlugin, "plugin", cfg.actAsPlugin, "Act as a ctx-* plugin itself and output JSON according to the plugin spec")
+	flag.BoolVar(&cfg.printCtxSpec, "ctx-spec", cfg.printCtxSpec, "Print ctx's own plugin capability document and exit")
+	flag.BoolVar(&cfg.queryCapabilities, "capabilities", cfg.queryCapabilities, "Query plugins with --ctx-spec for capability documents before running them (cached per binary)")
+	flag.StringVar(&cfg.mode, "mode", cfg.mode, "Requested plugin mode; plugins whose capability document does not list it are skipped (implies --capabilities)")
 	flag.StringVar(&cfg.cacheDir, "cache-dir", cfg.cacheDir, "Specify a base directory for plugins to use for caching (sets CTX_CACHE_DIR). Uses XDG default if empty.")
+	flag.BoolVar(&cfg.usage, "usage", cfg.usage, "Print a summary of metrics reported by plugins (token counts, cost) to stderr")
+	flag.BoolVar(&cfg.noCache, "no-cache", cfg.noCache, "Do not read or write cached plugin results (plugins opt in via cache_info)")
+	flag.BoolVar(&cfg.refreshCache, "refresh", cfg.refreshCache, "Ignore cached plugin results but store fresh ones")
 	flag.IntVar(&cfg.outputTokenBudget, "output-token-budget", cfg.outputTokenBudget, "Inform plugins of an estimated token budget for output (sets CTX_OUTPUT_TOKEN_BUDGET, 0 means unset)")
 	flag.IntVar(&cfg.thinkingTokenBudget, "thinking-token-budget", cfg.thinkingTokenBudget, "Inform plugins of an estimated token budget for internal work (sets CTX_THINKING_TOKEN_BUDGET, 0 means unset)")
 	flag.IntVar(&cfg.costBudgetCents, "cost-budget", cfg.costBudgetCents, "Inform plugins of an estimated cost budget in USD cents (sets CTX_COST_BUDGET_CENTS, 0 means unset)")
+	flag.BoolVar(&cfg.strict, "strict", cfg.strict, "Reject plugin output whose data violates its data_schema or data_schema_url (violations are reported otherwise)")
+	flag.StringVar(&cfg.budgetPolicy, "budget-policy", cfg.budgetPolicy, "How to enforce --output-token-budget and --cost-budget on plugin output: none (report only), truncate, drop (lowest priority first), or fail")
+	flag.StringVar(&cfg.only, "only", cfg.only, "Comma-separated plugins to run, by name (git or ctx-git), glob (go*), path, or capability tag (tag:vcs). Positional arguments are added to this list")
+	flag.StringVar(&cfg.skip, "skip", cfg.skip, "Comma-separated plugins not to run, using the same selectors as --only")
+	flag.StringVar(&cfg.priorities, "priority", cfg.priorities, "Comma-separated plugin names, highest priority first; used when enforcing budgets and by --sort=priority")
+	flag.StringVar(&cfg.sortOrder, "sort", cfg.sortOrder, "Order of plugins in the output: name (alphabetical) or priority (--priority order, then name)")
+	flag.StringVar(&cfg.duplicates, "duplicates", cfg.duplicates, "What to do when several plugins report the same name: first (keep the first in discovery order), namespace (keep all as name@path), or error")
 	flag.StringVar(&cfg.allowedTools, "allowed-tools", cfg.allowedTools, "Comma-separated list of external commands plugins are permitted to call (sets CTX_ALLOWED_TOOLS)")
-	flag.DurationVar(&cfg.pluginTimeout, "plugin-timeout", cfg.pluginTimeout, "Suggests a timeout duration for individual plugin execution (e.g., '30s', '1m'). Sets related CTX_* env vars. 0 means unset.")
-	flag.IntVar(&cfg.pluginRetries, "plugin-retries", cfg.pluginRetries, "Suggests a maximum number of retries plugins might attempt (sets CTX_RETRY_MAX, 0 means unset).")
+	flag.DurationVar(&cfg.pluginTimeout, "plugin-timeout", cfg.pluginTimeout, "Timeout for each plugin, measured from when it starts (e.g., '30s', '1m'). Sets related CTX_* env vars. 0 means unset.")
+	flag.DurationVar(&cfg.globalTimeout, "timeout", cfg.globalTimeout, "Wall-clock limit for the whole run; plugins still running are stopped and unstarted ones fail. 0 means unset.")
+	flag.DurationVar(&cfg.killGrace, "kill-grace", cfg.killGrace, "How long a timed-out plugin has to exit after SIGTERM before it is sent SIGKILL")
+	flag.IntVar(&cfg.pluginRetries, "plugin-retries", cfg.pluginRetries, "Re-run plugins up to this many times after a retryable failure (timeout, --retry-exit-codes, or {\"retryable\": true} on stderr). Also sets CTX_RETRY_MAX; 0 disables retries.")
+	flag.DurationVar(&cfg.retryBackoff, "retry-backoff", cfg.retryBackoff, "Delay before the first retry; doubled for each further attempt (up to 30s) with jitter")
+	flag.StringVar(&cfg.retryExitCodes, "retry-exit-codes", cfg.retryExitCodes, "Comma-separated plugin exit codes treated as retryable")
+	flag.StringVar(&cfg.xmlData, "xml-data", cfg.xmlData, "How XML output embeds plugin data: structured (nested elements with type attributes) or json (a JSON document in CDATA)")
 	flag.IntVar(&cfg.indent, "indent", cfg.indent, "Number of spaces for JSON/XML output indentation.")
 	flag.BoolVar(&cfg.summary, "summary", cfg.summary, "Output compact JSON/XML without indentation (overrides --indent).")
 	flag.IntVar(&cfg.maxParallelPlugins, "P", cfg.maxParallelPlugins, "Maximum number of plugins to run in parallel. Default is 1 for safety.")
 	flag.IntVar(&cfg.maxParallelPlugins, "parallel", cfg.maxParallelPlugins, "Maximum number of plugins to run in parallel. Default is 1 for safety.")
 	flag.BoolVar(&cfg.printSource, "show-source", false, "Request plugins to include their source code in txtar format (sets CTX_SHOW_SOURCE=true)")
-	flag.BoolVar(&cfg.verbose, "v", false, "Enable verbose output for debugging")
+	flag.BoolVar(&cfg.verbose, "v", false, "Enable verbose output for debugging, including plugin stderr as it is written")
+	flag.StringVar(&cfg.logDir, "log-dir", ctxrun.DefaultLogDir(), "Directory for per-session logs of plugin stderr (<session>.log); empty disables them")
+	flag.IntVar(&cfg.logKeep, "log-keep", ctxrun.DefaultLogRetention, "Number of session logs kept in --log-dir; older ones are removed after each run (0 keeps all)")
+	flag.IntVar(&cfg.embedStderr, "embed-stderr", cfg.embedStderr, "Include up to this many bytes of each successful plugin's stderr in the output metadata (0 disables)")
+	flag.StringVar(&cfg.approve, "approve", cfg.approve, "How to answer plugins that return requires_approval: prompt (on the terminal; deny if none), always, or never")
+	flag.StringVar(&cfg.trustFile, "trust-file", cfg.trustFile, "YAML file listing trusted plugins with expected SHA256 hashes (see 'ctx trust')")
+	flag.StringVar(&cfg.trustPolicy, "trust-policy", cfg.trustPolicy, "How to handle plugins failing trust verification: off, warn, or enforce (default: enforce if the trust file exists, otherwise off)")
+	flag.BoolVar(&cfg.failOnError, "fail-on-error", cfg.failOnError, fmt.Sprintf("Exit with status %d if any plugin fails (output is still printed).", exitPluginFailure))
+
+	switch command {
+	case "watch":
+		flag.CommandLine.Init("ctx watch", flag.ExitOnError)
+		flag.DurationVar(&cfg.watchInterval, "interval", cfg.watchInterval, "Also re-run plugins at this interval (0 means only on file changes)")
+		flag.StringVar(&cfg.watchDir, "watch-dir", ".", "Re-run plugins when files under this directory change (empty means only on --interval)")
+		flag.DurationVar(&cfg.watchPoll, "poll", ctxrun.DefaultPollInterval, "How often to scan --watch-dir where native file notifications are unavailable")
+	case "serve":
+		flag.CommandLine.Init("ctx serve", flag.ExitOnError)
+		flag.StringVar(&cfg.socket, "socket", defaultSocketPath(), "Unix socket to listen on")
+		flag.DurationVar(&cfg.maxAge, "max-age", cfg.maxAge, "Reuse each plugin's result for this long, unless it returns cache_info with cacheable: false (0 means only cache_info hints)")
+	case "mcp":
+		flag.CommandLine.Init("ctx mcp", flag.ExitOnError)
+	default:
+		flag.StringVar(&cfg.remote, "remote", cfg.remote, "Get context from the 'ctx serve' daemon on this Unix socket instead of running plugins; only output and selection flags apply")
+	}
 
 	flag.Parse()
+	if command == "serve" {
+		explicit := false
+		flag.Visit(func(f *flag.Flag) { explicit = explicit || f.Name == "approve" })
+		if !explicit {
+			cfg.approve = "never" // Nobody watches a daemon's terminal for prompts
+		}
+	}
+	cfg.command = command
+	cfg.selectors = flag.Args()
 	return cfg
 }
 
@@ -255,414 +364,203 @@ func getVersion() string {
 }
 
 func run(cfg *config) error {
-	// --- Plugin Discovery ---
-	if verbose {
-		log.Println("Discovering plugins in PATH...")
+	if cfg.remote != "" {
+		return runRemote(cfg)
 	}
-	discoveredPlugins, err := findPlugins()
-	if err != nil {
-		return fmt.Errorf("failed to discover plugins: %w", err)
+	var stream *ctxrun.NDJSONWriter
+	var streamOpts []ctxrun.Option
+	if cfg.stream || strings.EqualFold(cfg.outputFormat, "ndjson") {
+		stream = ctxrun.NewNDJSONWriter(os.Stdout)
+		streamOpts = append(streamOpts, ctxrun.WithResultHandler(stream.Outcome))
 	}
-	if verbose {
-		if len(discoveredPlugins) == 0 {
-			log.Println("No ctx-* plugins found in PATH.")
-		} else {
-			log.Printf("Found %d potential plugin(s).", len(discoveredPlugins))
-		}
+	runner, err := newRunner(cfg, streamOpts...)
+	if err != nil {
+		return err
 	}
 
 	if cfg.listPlugins {
-		fmt.Println("Discovered potential plugins (executables named ctx-* in PATH):")
+		discoveredPlugins, warnings, err := runner.Discover()
+		if err != nil {
+			return fmt.Errorf("failed to discover plugins: %w", err)
+		}
+		discoveredPlugins, selectWarnings, err := runner.Select(context.Background(), discoveredPlugins)
+		if err != nil {
+			return err
+		}
+		for _, w := range append(warnings, selectWarnings...) {
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", w)
+		}
+		fmt.Println("Discovered potential plugins (executables named ctx-* in plugin directories and PATH):")
 		if len(discoveredPlugins) == 0 {
 			fmt.Println("  (None found)")
 		}
 		for _, p := range discoveredPlugins {
 			fmt.Printf("  - %s\n", p) // Show full path for clarity
+			if cfg.verbose {
+				printCapabilities(runner, p)
+			}
 		}
 		return nil
 	}
 
-	if len(discoveredPlugins) == 0 {
-		if verbose {
-			log.Println("No plugins found to execute.")
+	res, err := runner.Run(context.Background())
+	if stream != nil && res != nil {
+		// Plugin lines are already written; the summary carries the outcome.
+		if err := stream.Summary(res); err != nil {
+			return fmt.Errorf("failed to write output: %w", err)
 		}
-		emptyResult := map[string]PluginData{}
-		emptyOutput, _ := formatOutput(emptyResult, cfg) // Use empty map
-		fmt.Println(emptyOutput)
-		return nil
-	}
-
-	// --- Plugin Execution ---
-	if verbose {
-		log.Printf("Executing %d potential plugin(s)...", len(discoveredPlugins))
-	}
-	sessionID := getSessionID()
-	pluginEnv := getPluginEnv(cfg, sessionID, ambientEnvKeysToPropagate)
-	if verbose {
-		log.Printf("Session ID: %s", sessionID)
 	}
-
-	// Create execution context
-	var execCtx context.Context
-	var cancel context.CancelFunc
-	
-	if cfg.pluginTimeout > 0 {
-		execCtx, cancel = context.WithTimeout(context.Background(), cfg.pluginTimeout)
-		defer cancel()
-	} else {
-		execCtx = context.Background()
-	}
-
-	results := executePlugins(execCtx, discoveredPlugins, pluginEnv, cfg.maxParallelPlugins)
-	if verbose {
-		log.Printf("Finished execution. Aggregated results from %d plugin(s).", len(results))
-	}
-
-	// --- Output Formatting ---
-	output, err := formatOutput(results, cfg) // Pass full config
 	if err != nil {
-		return fmt.Errorf("failed to format output: %w", err)
+		return err
 	}
 
-	fmt.Println(output)
-	return nil
-}
-
-// findPlugins searches PATH for executables starting with "ctx-".
-// Returns a list of full paths to potential plugins.
-func findPlugins() ([]string, error) {
-	var plugins []string
-	pathEnv := os.Getenv("PATH")
-	if pathEnv == "" {
-		return nil, errors.New("PATH environment variable is not set")
-	}
-	paths := filepath.SplitList(pathEnv)
-
-	checked := make(map[string]struct{})
-	selfPath, _ := os.Executable() // Get our own path to ensure we don't create infinite loop
-
-	for _, path := range paths {
-		if path == "" {
-			continue
-		}
-		absPath, err := filepath.Abs(path)
-		if err != nil {
-			continue
-		}
-		if _, ok := checked[absPath]; ok {
-			continue
-		}
-		checked[absPath] = struct{}{}
-
-		files, err := os.ReadDir(absPath)
+	// --- Output Formatting ---
+	if stream == nil {
+		output, err := ctxrun.Format(res, formatOptions(cfg))
 		if err != nil {
-			continue
-		}
-
-		for _, file := range files {
-			if file.IsDir() {
-				continue
-			}
-			
-			fileName := file.Name()
-			if !strings.HasPrefix(fileName, "ctx-") {
-				continue
-			}
-			
-			pluginPath := filepath.Join(absPath, fileName)
-			
-			// Skip ourselves to avoid infinite recursion
-			if pluginPath == selfPath {
-				continue
-			}
-			
-			info, err := file.Info()
-			if err != nil || !(info.Mode()&0111 != 0 || runtime.GOOS == "windows") {
-				continue
-			}
-			
-			plugins = append(plugins, pluginPath)
+			return fmt.Errorf("failed to format output: %w", err)
 		}
+		fmt.Print(output) // Format ends output with a newline; another would corrupt txtar
 	}
-	return plugins, nil
-}
-
-// getSessionID retrieves the session ID from the environment or generates a new timestamp-based ID.
-func getSessionID() string {
-	if sessionID := os.Getenv(ctxSessionEnvKey); sessionID != "" {
-		return sessionID
+	for _, w := range res.Warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", w)
 	}
-	return fmt.Sprintf("ctx_%d", time.Now().Unix())
-}
-
-// getCurrentShlvl reads the current CTX_SHLVL or SHLVL, defaulting to 0.
-func getCurrentShlvl() int {
-	levelStr := os.Getenv(ctxShlvlEnvKey)
-	if levelStr == "" {
-		levelStr = os.Getenv("SHLVL")
+	if cfg.usage {
+		fmt.Fprintf(os.Stderr, "Usage: %s\n", res.Usage())
 	}
-	level, err := strconv.Atoi(levelStr)
-	if err != nil || level < 0 {
-		return 0
+	if cfg.failOnError && len(res.Errors) > 0 {
+		log.Printf("%d plugin(s) failed.", len(res.Errors))
+		os.Exit(exitPluginFailure)
 	}
-	return level
+	return nil
 }
 
-// getPluginEnv creates the environment slice for plugin execution.
-func getPluginEnv(cfg *config, sessionID string, ambientKeysToPropagate []string) []string {
-	currentEnv := os.Environ()
-	finalEnv := make([]string, 0, len(currentEnv)+2+len(ambientKeysToPropagate)+8)
-
-	varsToSet := make(map[string]string)
-	managedKeys := make(map[string]struct{})
-
-	// 1. Set CTX_SESSION
-	varsToSet[ctxSessionEnvKey] = sessionID
-	managedKeys[ctxSessionEnvKey] = struct{}{}
-
-	// 2. Set CTX_SHLVL
-	currentLevel := getCurrentShlvl()
-	varsToSet[ctxShlvlEnvKey] = strconv.Itoa(currentLevel + 1)
-	managedKeys[ctxShlvlEnvKey] = struct{}{}
-	managedKeys["SHLVL"] = struct{}{} // Don't inherit standard SHLVL
-
-	// 3. Handle ambient context propagation (Otel etc)
-	for _, key := range ambientKeysToPropagate {
-		if value, exists := os.LookupEnv(key); exists {
-			varsToSet[key] = value
-		}
-		managedKeys[key] = struct{}{}
-	}
-
-	// 4. Handle configuration propagation from flags
-	var cacheDirToSet string
-	if cfg.cacheDir != "" {
-		absCacheDir, err := filepath.Abs(cfg.cacheDir)
-		if err == nil {
-			cacheDirToSet = absCacheDir
-		} else {
-			log.Printf("Warning: Could not resolve absolute path for --cache-dir '%s'. CTX_CACHE_DIR will not be set.", cfg.cacheDir)
-		}
-	} else {
-		xdgCacheHome := os.Getenv("XDG_CACHE_HOME")
-		if xdgCacheHome == "" {
-			homeDir, err := os.UserHomeDir()
-			if err == nil {
-				xdgCacheHome = filepath.Join(homeDir, ".cache")
-			}
-		}
-		if xdgCacheHome != "" {
-			cacheDirToSet = filepath.Join(xdgCacheHome, "ctx")
-		}
-	}
-	if cacheDirToSet != "" {
-		varsToSet["CTX_CACHE_DIR"] = cacheDirToSet
-		managedKeys["CTX_CACHE_DIR"] = struct{}{}
-	}
-
-	if cfg.outputTokenBudget > 0 {
-		varsToSet["CTX_OUTPUT_TOKEN_BUDGET"] = strconv.Itoa(cfg.outputTokenBudget)
-		managedKeys["CTX_OUTPUT_TOKEN_BUDGET"] = struct{}{}
-	}
-	if cfg.thinkingTokenBudget > 0 {
-		varsToSet["CTX_THINKING_TOKEN_BUDGET"] = strconv.Itoa(cfg.thinkingTokenBudget)
-		managedKeys["CTX_THINKING_TOKEN_BUDGET"] = struct{}{}
-	}
-	if cfg.costBudgetCents > 0 {
-		varsToSet["CTX_COST_BUDGET_CENTS"] = strconv.Itoa(cfg.costBudgetCents)
-		managedKeys["CTX_COST_BUDGET_CENTS"] = struct{}{}
+// newRunner builds a Runner from flags, followed by extra options.
+func newRunner(cfg *config, extra ...ctxrun.Option) (*ctxrun.Runner, error) {
+	approver, err := approverFor(cfg.approve)
+	if err != nil {
+		return nil, err
 	}
-	if cfg.allowedTools != "" {
-		varsToSet["CTX_ALLOWED_TOOLS"] = cfg.allowedTools
-		managedKeys["CTX_ALLOWED_TOOLS"] = struct{}{}
+	trust, trustPolicy, err := loadTrust(cfg)
+	if err != nil {
+		return nil, err
 	}
-	if cfg.pluginTimeout > 0 {
-		timeoutSec := int(cfg.pluginTimeout.Seconds())
-		if timeoutSec > 0 {
-			deadlineTs := time.Now().Add(cfg.pluginTimeout).Unix()
-			varsToSet["CTX_TIMEOUT_SECONDS"] = strconv.Itoa(timeoutSec)
-			varsToSet["CTX_DEADLINE_TIMESTAMP"] = strconv.FormatInt(deadlineTs, 10)
-			managedKeys["CTX_TIMEOUT_SECONDS"] = struct{}{}
-			managedKeys["CTX_DEADLINE_TIMESTAMP"] = struct{}{}
-		}
+	budgetPolicy, err := ctxrun.ParseBudgetPolicy(cfg.budgetPolicy)
+	if err != nil {
+		return nil, err
 	}
-	if cfg.pluginRetries > 0 {
-		varsToSet["CTX_RETRY_MAX"] = strconv.Itoa(cfg.pluginRetries)
-		managedKeys["CTX_RETRY_MAX"] = struct{}{}
+	if cfg.sortOrder != "name" && cfg.sortOrder != "priority" {
+		return nil, fmt.Errorf("invalid --sort %q (want name or priority)", cfg.sortOrder)
 	}
-	
-	// Set source flag if enabled
-	if cfg.printSource {
-		// When showing source, it's always in txtar format
-		varsToSet[ctxShowSourceEnvKey] = "true"
-		managedKeys[ctxShowSourceEnvKey] = struct{}{}
+	duplicatePolicy, err := ctxrun.ParseDuplicatePolicy(cfg.duplicates)
+	if err != nil {
+		return nil, err
 	}
-	
-	// managedKeys["CTX_APPROVED"] = struct{}{} // If approval flow implemented
+	retryExitCodes, err := parseExitCodes(cfg.retryExitCodes)
+	if err != nil {
+		return nil, err
+	}
+	opts := append(runnerOptions(cfg),
+		ctxrun.WithApprover(approver),
+		ctxrun.WithTrust(trust, trustPolicy),
+		ctxrun.WithBudgetPolicy(budgetPolicy),
+		ctxrun.WithPriorities(parsePriorities(cfg.priorities)),
+		ctxrun.WithRetryExitCodes(retryExitCodes),
+		ctxrun.WithDuplicatePolicy(duplicatePolicy),
+	)
+	return ctxrun.New(append(opts, extra...)...), nil
+}
 
-	// Filter currentEnv, keeping only non-managed vars
-	for _, envVar := range currentEnv {
-		parts := strings.SplitN(envVar, "=", 2)
-		if len(parts) > 0 {
-			if _, manageThis := managedKeys[parts[0]]; !manageThis {
-				finalEnv = append(finalEnv, envVar)
-			}
-		}
-	}
-	// Add the explicitly managed vars
-	for key, value := range varsToSet {
-		finalEnv = append(finalEnv, fmt.Sprintf("%s=%s", key, value))
+// runnerOptions translates parsed flags into ctxrun options.
+func runnerOptions(cfg *config) []ctxrun.Option {
+	return []ctxrun.Option{
+		ctxrun.WithCacheDir(cfg.cacheDir),
+		ctxrun.WithNoCache(cfg.noCache),
+		ctxrun.WithRefreshCache(cfg.refreshCache),
+		ctxrun.WithOutputTokenBudget(cfg.outputTokenBudget),
+		ctxrun.WithThinkingTokenBudget(cfg.thinkingTokenBudget),
+		ctxrun.WithCostBudget(cfg.costBudgetCents),
+		ctxrun.WithAllowedTools(cfg.allowedTools),
+		ctxrun.WithPluginTimeout(cfg.pluginTimeout),
+		ctxrun.WithGlobalTimeout(cfg.globalTimeout),
+		ctxrun.WithKillGrace(cfg.killGrace),
+		ctxrun.WithPluginRetries(cfg.pluginRetries),
+		ctxrun.WithRetryBackoff(cfg.retryBackoff),
+		ctxrun.WithMaxParallel(cfg.maxParallelPlugins),
+		ctxrun.WithShowSource(cfg.printSource),
+		ctxrun.WithCapabilities(cfg.queryCapabilities),
+		ctxrun.WithMode(cfg.mode),
+		ctxrun.WithStrictSchemas(cfg.strict),
+		ctxrun.WithPluginConfig(cfg.pluginConfig),
+		ctxrun.WithPluginDirs(cfg.pluginDirs...),
+		ctxrun.WithSelection(append(splitList(cfg.only), cfg.selectors...), splitList(cfg.skip)),
+		ctxrun.WithLogger(log.Default()), // Filtered by verboseLogger
+		ctxrun.WithLogDir(cfg.logDir),
+		ctxrun.WithLogRetention(cfg.logKeep),
+		ctxrun.WithStderrInOutput(cfg.embedStderr),
 	}
-	return finalEnv
 }
 
-// executePlugins runs discovered plugins concurrently and aggregates their JSON output.
-func executePlugins(ctx context.Context, pluginPaths []string, pluginEnv []string, maxParallel int) map[string]PluginData {
-	results := make(map[string]PluginData)
-	var wg sync.WaitGroup
-	var mu sync.Mutex
-	
-	// Semaphore to limit concurrent executions
-	semaphore := make(chan struct{}, maxParallel)
-
-	for _, pluginPath := range pluginPaths {
-		wg.Add(1)
-		semaphore <- struct{}{} // Acquire semaphore token
-		go func(pPath string) {
-			defer wg.Done()
-			defer func() { <-semaphore }() // Release semaphore token
-			execName := filepath.Base(pPath)
-
-			// Skip the help flag check - we're more lenient now
-			if verbose {
-				log.Printf("[%s] Running plugin...", execName)
-			}
-			
-			cmd := exec.CommandContext(ctx, pPath)
-			cmd.Env = pluginEnv
-			stdout, err := cmd.Output()
-
-			if err != nil {
-				if verbose {
-					errMsg := fmt.Sprintf("failed to execute plugin '%s': %v", execName, err)
-					var exitErr *exec.ExitError
-					if errors.As(err, &exitErr) {
-						errMsg = fmt.Sprintf("%s. Stderr: %s", errMsg, string(exitErr.Stderr))
-					}
-					log.Printf("[%s] Error: %s", execName, errMsg)
-				}
-				return
-			}
+// listFlag is a repeatable string flag.
+type listFlag []string
 
-			var data PluginData
-			if err := json.Unmarshal(stdout, &data); err != nil {
-				if verbose {
-					const maxLogLen = 200
-					outStr := string(stdout)
-					if len(outStr) > maxLogLen {
-						outStr = outStr[:maxLogLen] + "..."
-					}
-					log.Printf("[%s] Error: failed parsing JSON output: %v. Output (truncated): %s", execName, err, outStr)
-				}
-				return
-			}
-
-			if data.Name == "" || data.Version == "" || data.Data == nil {
-				if verbose {
-					log.Printf("[%s] Error: Plugin output missing required field ('name', 'version', or 'data'). Skipping.", execName)
-				}
-				return
-			}
+func (l *listFlag) String() string { return strings.Join(*l, ",") }
 
-			if verbose {
-				log.Printf("[%s] Success (Reported Version: %s).", data.Name, data.Version)
-			}
-
-			mu.Lock()
-			defer mu.Unlock()
-			if _, exists := results[data.Name]; exists && verbose {
-				log.Printf("Warning: Duplicate plugin name '%s' detected (from '%s'). Overwriting previous result.", data.Name, execName)
-			}
-			results[data.Name] = data
+func (l *listFlag) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
 
-		}(pluginPath)
+// splitList splits a comma-separated flag value, dropping empty entries.
+func splitList(list string) []string {
+	var items []string
+	for _, s := range strings.Split(list, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			items = append(items, s)
+		}
 	}
-
-	wg.Wait()
-	return results
+	return items
 }
 
-// formatOutput converts the aggregated results to the desired string format.
-func formatOutput(results map[string]PluginData, cfg *config) (string, error) {
-	outputData := make(map[string]any, len(results))
-	pluginMetas := make(map[string]PluginData) // Store original meta for XML
-	for name, result := range results {
-		pluginMetas[name] = result // Keep version etc.
-		var data any
-		if err := json.Unmarshal(result.Data, &data); err != nil {
-			log.Printf("Warning: Could not unmarshal stored raw data for plugin '%s', outputting as string: %v", name, err)
-			outputData[name] = string(result.Data) // Fallback
-		} else {
-			outputData[name] = data
+// parseExitCodes parses a comma-separated --retry-exit-codes list.
+func parseExitCodes(list string) ([]int, error) {
+	var codes []int
+	for _, s := range splitList(list) {
+		code, err := strconv.Atoi(s)
+		if err != nil || code <= 0 || code > 255 {
+			return nil, fmt.Errorf("invalid --retry-exit-codes entry %q", s)
 		}
+		codes = append(codes, code)
 	}
+	return codes, nil
+}
 
-	var outputBytes []byte
-	var err error
-	outputFormat := strings.ToLower(cfg.outputFormat)
-	indentStr := ""
-	prefixStr := ""
-	if !cfg.summary && cfg.indent > 0 {
-		indentStr = strings.Repeat(" ", cfg.indent)
-		prefixStr = "" // xml/json handle prefix internally via indent
+// parsePriorities turns a --priority list into priorities, giving the first
+// name the highest value.
+func parsePriorities(list string) map[string]int {
+	if list == "" {
+		return nil
 	}
-
-	switch outputFormat {
-	case "json":
-		if indentStr == "" {
-			outputBytes, err = json.Marshal(outputData)
-		} else {
-			outputBytes, err = json.MarshalIndent(outputData, prefixStr, indentStr)
-		}
-		if err != nil {
-			return "", fmt.Errorf("failed to marshal results to JSON: %w", err)
-		}
-	case "xml":
-		xmlRoot := XMLResults{SessionID: os.Getenv(ctxSessionEnvKey)} // Use the current session ID
-		for name, data := range outputData {
-			jsonDataBytes, jsonErr := json.Marshal(data) // Marshal just the data part
-			if jsonErr != nil {
-				log.Printf("Warning: Could not re-marshal data for plugin '%s' to JSON for XML embedding: %v", name, jsonErr)
-				jsonDataBytes = []byte("Error re-marshaling data")
-			}
-			meta := pluginMetas[name] // Get original metadata
-			xmlPlugin := XMLPlugin{Name: meta.Name, Version: meta.Version, Data: xml.CharData(jsonDataBytes)}
-			xmlRoot.Plugins = append(xmlRoot.Plugins, xmlPlugin)
-		}
-		if indentStr == "" {
-			outputBytes, err = xml.Marshal(xmlRoot)
-		} else {
-			outputBytes, err = xml.MarshalIndent(xmlRoot, prefixStr, indentStr)
-		}
-		if err != nil {
-			return "", fmt.Errorf("failed to marshal results to XML: %w", err)
-		}
-		// Add XML header manually if needed, MarshalIndent doesn't include it.
-		outputBytes = append([]byte(xml.Header), outputBytes...)
-
-	case "yaml":
-		fallthrough
-	default: // Default to YAML
-		// YAML marshaller doesn't support indentation control easily in the standard lib
-		outputBytes, err = yaml.Marshal(outputData)
-		if err != nil {
-			return "", fmt.Errorf("failed to marshal results to YAML: %w", err)
+	names := strings.Split(list, ",")
+	priority := make(map[string]int, len(names))
+	for i, name := range names {
+		if name = strings.TrimSpace(name); name != "" {
+			priority[name] = len(names) - i
 		}
 	}
+	return priority
+}
 
-	// Add trailing newline if not empty and not already present
-	if len(outputBytes) > 0 && !bytes.HasSuffix(outputBytes, []byte("\n")) {
-		return string(outputBytes) + "\n", nil
+// formatOptions translates parsed flags into ctxrun format options.
+func formatOptions(cfg *config) ctxrun.FormatOptions {
+	opts := ctxrun.FormatOptions{
+		Format:  cfg.outputFormat,
+		Indent:  cfg.indent,
+		Summary: cfg.summary,
+		XMLData: cfg.xmlData,
+	}
+	if cfg.sortOrder == "priority" {
+		opts.Priority = parsePriorities(cfg.priorities)
 	}
-	return string(outputBytes), nil
+	return opts
 }
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/tmc/ctx/ctxrun"
+)
+
+// ctx mcp serves the Model Context Protocol over stdio: newline-delimited
+// JSON-RPC 2.0 messages on stdin and stdout. Each discovered plugin is a
+// resource (ctx://plugins/<name>), read by running it, and a tool of the
+// same name, whose arguments set the CTX_* budget variables for the run.
+
+// mcpProtocolVersions lists the protocol revisions ctx supports, newest first.
+var mcpProtocolVersions = []string{"2025-06-18", "2025-03-26", "2024-11-05"}
+
+const mcpResourcePrefix = "ctx://plugins/"
+
+// JSON-RPC and MCP error codes.
+const (
+	rpcParseError       = -32700
+	rpcInvalidRequest   = -32600
+	rpcMethodNotFound   = -32601
+	rpcInvalidParams    = -32602
+	rpcInternalError    = -32603
+	mcpResourceNotFound = -32002
+)
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"` // Absent for notifications
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    any    `json:"data,omitempty"`
+}
+
+func (e *rpcError) Error() string { return e.Message }
+
+// mcpServer handles MCP requests. Requests run concurrently, so a slow
+// plugin does not block others, and can be cancelled by the client.
+type mcpServer struct {
+	cfg *config
+
+	mu      sync.Mutex // Guards out and cancels
+	out     *json.Encoder
+	cancels map[string]context.CancelFunc // By request ID
+}
+
+// runMCP implements "ctx mcp".
+func runMCP(cfg *config) error {
+	if _, err := newRunner(cfg); err != nil { // Report bad flags before serving
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	s := &mcpServer{cfg: cfg, out: json.NewEncoder(os.Stdout), cancels: make(map[string]context.CancelFunc)}
+	return s.serve(ctx, os.Stdin)
+}
+
+// serve reads messages from in until it is closed or ctx is done.
+func (s *mcpServer) serve(ctx context.Context, in io.Reader) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	sc := bufio.NewScanner(in)
+	sc.Buffer(make([]byte, 64*1024), 16<<20)
+	lines := make(chan []byte)
+	go func() {
+		defer close(lines)
+		for sc.Scan() {
+			lines <- append([]byte(nil), sc.Bytes()...)
+		}
+	}()
+	for {
+		var line []byte
+		select {
+		case <-ctx.Done():
+			return nil
+		case l, ok := <-lines:
+			if !ok {
+				return sc.Err()
+			}
+			line = l
+		}
+		if len(strings.TrimSpace(string(line))) == 0 {
+			continue
+		}
+		var req rpcRequest
+		if err := json.Unmarshal(line, &req); err != nil {
+			s.reply(nil, nil, &rpcError{Code: rpcParseError, Message: err.Error()})
+			continue
+		}
+		if req.JSONRPC != "2.0" || req.Method == "" {
+			if req.ID != nil {
+				s.reply(req.ID, nil, &rpcError{Code: rpcInvalidRequest, Message: "not a JSON-RPC 2.0 request"})
+			}
+			continue // Responses and malformed notifications are ignored
+		}
+		if req.ID == nil {
+			s.notify(req)
+			continue
+		}
+		reqCtx, cancel := context.WithCancel(ctx)
+		s.mu.Lock()
+		_, inUse := s.cancels[string(req.ID)]
+		if !inUse {
+			s.cancels[string(req.ID)] = cancel
+		}
+		s.mu.Unlock()
+		if inUse {
+			// Replacing the entry would leave the first request uncancellable.
+			cancel()
+			s.reply(req.ID, nil, &rpcError{Code: rpcInvalidRequest, Message: fmt.Sprintf("request ID %s is already in use", req.ID)})
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := s.handle(reqCtx, req)
+			s.mu.Lock()
+			delete(s.cancels, string(req.ID))
+			s.mu.Unlock()
+			cancelled := reqCtx.Err() != nil && ctx.Err() == nil
+			cancel()
+			if cancelled {
+				return // The client no longer expects a response
+			}
+			var rerr *rpcError
+			if err != nil && !errors.As(err, &rerr) {
+				rerr = &rpcError{Code: rpcInternalError, Message: err.Error()}
+			}
+			s.reply(req.ID, result, rerr)
+		}()
+	}
+}
+
+// notify handles a notification. Only cancellation needs action.
+func (s *mcpServer) notify(req rpcRequest) {
+	if req.Method != "notifications/cancelled" {
+		return
+	}
+	var params struct {
+		RequestID json.RawMessage `json:"requestId"`
+	}
+	if json.Unmarshal(req.Params, &params) != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cancel, ok := s.cancels[string(params.RequestID)]; ok {
+		cancel()
+	}
+}
+
+func (s *mcpServer) reply(id json.RawMessage, result any, err *rpcError) {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	resp := rpcResponse{JSONRPC: "2.0", ID: id, Result: result, Error: err}
+	if err == nil && result == nil {
+		resp.Result = struct{}{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.out.Encode(resp)
+}
+
+func (s *mcpServer) handle(ctx context.Context, req rpcRequest) (any, error) {
+	switch req.Method {
+	case "initialize":
+		var params struct {
+			ProtocolVersion string `json:"protocolVersion"`
+		}
+		json.Unmarshal(req.Params, &params)
+		version := mcpProtocolVersions[0]
+		if contains(mcpProtocolVersions, params.ProtocolVersion) {
+			version = params.ProtocolVersion
+		}
+		return map[string]any{
+			"protocolVersion": version,
+			"capabilities":    map[string]any{"resources": struct{}{}, "tools": struct{}{}},
+			"serverInfo":      map[string]string{"name": "ctx", "version": getVersion()},
+		}, nil
+	case "ping":
+		return struct{}{}, nil
+	case "resources/list":
+		return s.listResources(ctx)
+	case "resources/read":
+		var params struct {
+			URI string `json:"uri"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+		}
+		return s.readResource(ctx, params.URI)
+	case "tools/list":
+		return s.listTools(ctx)
+	case "tools/call":
+		var params struct {
+			Name      string          `json:"name"`
+			Arguments json.RawMessage `json:"arguments"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+		}
+		return s.callTool(ctx, params.Name, params.Arguments)
+	}
+	return nil, &rpcError{Code: rpcMethodNotFound, Message: fmt.Sprintf("method %q not found", req.Method)}
+}
+
+// mcpPlugin is a discovered plugin as exposed over MCP.
+type mcpPlugin struct {
+	name        string // PluginName, used as the tool name and in the resource URI
+	path        string
+	description string
+}
+
+// plugins discovers and selects plugins as a normal run would. With
+// --capabilities, descriptions come from the plugins' capability documents.
+func (s *mcpServer) plugins(ctx context.Context) ([]mcpPlugin, error) {
+	runner, err := newRunner(s.cfg)
+	if err != nil {
+		return nil, err
+	}
+	paths, _, err := runner.Discover()
+	if err != nil {
+		return nil, err
+	}
+	paths, _, err = runner.Select(ctx, paths)
+	if err != nil {
+		return nil, err
+	}
+	plugins := make([]mcpPlugin, 0, len(paths))
+	for _, p := range paths {
+		mp := mcpPlugin{name: ctxrun.PluginName(p), path: p}
+		if s.cfg.queryCapabilities {
+			if caps, err := runner.Capabilities(ctx, p); err == nil && caps != nil {
+				mp.description = caps.Description
+			}
+		}
+		if mp.description == "" {
+			mp.description = fmt.Sprintf("Context gathered by the %s plugin (%s).", mp.name, p)
+		}
+		plugins = append(plugins, mp)
+	}
+	return plugins, nil
+}
+
+// plugin finds the plugin named name.
+func (s *mcpServer) plugin(ctx context.Context, name string) (mcpPlugin, bool, error) {
+	plugins, err := s.plugins(ctx)
+	if err != nil {
+		return mcpPlugin{}, false, err
+	}
+	for _, p := range plugins {
+		if p.name == name {
+			return p, true, nil
+		}
+	}
+	return mcpPlugin{}, false, nil
+}
+
+func (s *mcpServer) listResources(ctx context.Context) (any, error) {
+	plugins, err := s.plugins(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resources := make([]map[string]string, 0, len(plugins))
+	for _, p := range plugins {
+		resources = append(resources, map[string]string{
+			"uri":         mcpResourcePrefix + p.name,
+			"name":        p.name,
+			"description": p.description,
+			"mimeType":    "application/json",
+		})
+	}
+	return map[string]any{"resources": resources}, nil
+}
+
+func (s *mcpServer) readResource(ctx context.Context, uri string) (any, error) {
+	name := strings.TrimPrefix(uri, mcpResourcePrefix)
+	p, ok, err := s.plugin(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if !ok || !strings.HasPrefix(uri, mcpResourcePrefix) {
+		return nil, &rpcError{Code: mcpResourceNotFound, Message: "resource not found", Data: map[string]string{"uri": uri}}
+	}
+	data, perr, err := s.runPlugin(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	if perr != nil {
+		return nil, pluginRPCError(perr)
+	}
+	return map[string]any{"contents": []map[string]string{{"uri": uri, "mimeType": "application/json", "text": string(data.Data)}}}, nil
+}
+
+// mcpToolArgs are the arguments every plugin tool accepts.
+type mcpToolArgs struct {
+	OutputTokenBudget   int `json:"output_token_budget,omitempty"`
+	ThinkingTokenBudget int `json:"thinking_token_budget,omitempty"`
+	CostBudgetCents     int `json:"cost_budget_cents,omitempty"`
+}
+
+var mcpToolInputSchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"output_token_budget":   map[string]any{"type": "integer", "minimum": 0, "description": "Estimated token budget for the plugin's output (CTX_OUTPUT_TOKEN_BUDGET)"},
+		"thinking_token_budget": map[string]any{"type": "integer", "minimum": 0, "description": "Estimated token budget for internal work (CTX_THINKING_TOKEN_BUDGET)"},
+		"cost_budget_cents":     map[string]any{"type": "integer", "minimum": 0, "description": "Estimated cost budget in USD cents (CTX_COST_BUDGET_CENTS)"},
+	},
+	"additionalProperties": false,
+}
+
+func (s *mcpServer) listTools(ctx context.Context) (any, error) {
+	plugins, err := s.plugins(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tools := make([]map[string]any, 0, len(plugins))
+	for _, p := range plugins {
+		tools = append(tools, map[string]any{
+			"name":        p.name,
+			"description": p.description,
+			"inputSchema": mcpToolInputSchema,
+		})
+	}
+	return map[string]any{"tools": tools}, nil
+}
+
+// callTool runs a plugin. Plugin failures are tool results with isError
+// set, as MCP prescribes, so the model can see them.
+func (s *mcpServer) callTool(ctx context.Context, name string, rawArgs json.RawMessage) (any, error) {
+	var args mcpToolArgs
+	if len(rawArgs) > 0 && string(rawArgs) != "null" {
+		dec := json.NewDecoder(strings.NewReader(string(rawArgs)))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&args); err != nil {
+			return nil, &rpcError{Code: rpcInvalidParams, Message: fmt.Sprintf("invalid arguments for %s: %v", name, err)}
+		}
+	}
+	p, ok, err := s.plugin(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: fmt.Sprintf("unknown tool %q", name)}
+	}
+	var opts []ctxrun.Option
+	if args.OutputTokenBudget > 0 {
+		opts = append(opts, ctxrun.WithOutputTokenBudget(args.OutputTokenBudget))
+	}
+	if args.ThinkingTokenBudget > 0 {
+		opts = append(opts, ctxrun.WithThinkingTokenBudget(args.ThinkingTokenBudget))
+	}
+	if args.CostBudgetCents > 0 {
+		opts = append(opts, ctxrun.WithCostBudget(args.CostBudgetCents))
+	}
+	data, perr, err := s.runPlugin(ctx, p, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if perr != nil {
+		return map[string]any{"content": []map[string]string{{"type": "text", "text": perr.Error() + stderrSuffix(perr)}}, "isError": true}, nil
+	}
+	return map[string]any{"content": []map[string]string{{"type": "text", "text": string(data.Data)}}, "isError": false}, nil
+}
+
+// runPlugin executes a single plugin with the flags' settings and extra
+// options. A plugin failure is returned as a *ctxrun.PluginError; a run
+// failed by the budget policy is a JSON-RPC error carrying the budget report.
+func (s *mcpServer) runPlugin(ctx context.Context, p mcpPlugin, extra ...ctxrun.Option) (ctxrun.PluginData, *ctxrun.PluginError, error) {
+	runner, err := newRunner(s.cfg, extra...)
+	if err != nil {
+		return ctxrun.PluginData{}, nil, err
+	}
+	res, err := runner.Execute(ctx, []string{p.path})
+	if errors.Is(err, ctxrun.ErrBudgetExceeded) {
+		return ctxrun.PluginData{}, nil, &rpcError{Code: rpcInternalError, Message: err.Error(), Data: res.Budget}
+	}
+	if err != nil {
+		return ctxrun.PluginData{}, nil, err
+	}
+	if len(res.Errors) > 0 {
+		return ctxrun.PluginData{}, res.Errors[0], nil
+	}
+	for _, data := range res.Plugins {
+		return data, nil, nil
+	}
+	if len(res.Skipped) > 0 {
+		return ctxrun.PluginData{}, nil, fmt.Errorf("plugin %s does not support mode %q", p.name, s.cfg.mode)
+	}
+	return ctxrun.PluginData{}, nil, fmt.Errorf("plugin %s produced no result", p.name)
+}
+
+// pluginRPCError translates a plugin failure into a JSON-RPC error.
+func pluginRPCError(perr *ctxrun.PluginError) *rpcError {
+	return &rpcError{Code: rpcInternalError, Message: perr.Error(), Data: perr}
+}
+
+func stderrSuffix(perr *ctxrun.PluginError) string {
+	if perr.Stderr == "" {
+		return ""
+	}
+	return "\nstderr:\n" + perr.Stderr
+}
+
+// contains reports whether list contains s.
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
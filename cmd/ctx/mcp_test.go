@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mcpReply is a decoded JSON-RPC response.
+type mcpReply struct {
+	ID     json.RawMessage `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *rpcError       `json:"error"`
+}
+
+// newTestMCPServer returns a server for plugins in a temporary directory,
+// which holds ctx-echo, ctx-fail and ctx-slow.
+func newTestMCPServer(t *testing.T) *mcpServer {
+	t.Helper()
+	dir := t.TempDir()
+	plugins := map[string]string{
+		"ctx-echo": `echo '{"name":"echo","version":"1","data":{"budget":"'$CTX_OUTPUT_TOKEN_BUDGET'"}}'`,
+		"ctx-fail": "echo broken >&2\nexit 3",
+		"ctx-slow": "exec sleep 10",
+	}
+	for name, script := range plugins {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+script+"\n"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", "/usr/bin:/bin")
+	t.Setenv("CTX_PLUGIN_PATH", "")
+	chdir(t, t.TempDir())
+	cfg := parseArgs(t, "mcp", "--plugin-dir", dir, "--trust-policy", "off", "--no-cache")
+	return &mcpServer{cfg: cfg, cancels: make(map[string]context.CancelFunc)}
+}
+
+// serveMCP sends lines to s and returns its responses, in the order written.
+func serveMCP(t *testing.T, s *mcpServer, lines ...string) []mcpReply {
+	t.Helper()
+	var out bytes.Buffer
+	s.out = json.NewEncoder(&out)
+	if err := s.serve(context.Background(), strings.NewReader(strings.Join(lines, "\n")+"\n")); err != nil {
+		t.Fatal(err)
+	}
+	var replies []mcpReply
+	dec := json.NewDecoder(&out)
+	for dec.More() {
+		var r mcpReply
+		if err := dec.Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		replies = append(replies, r)
+	}
+	return replies
+}
+
+// only returns the single reply in replies, failing the test otherwise.
+func only(t *testing.T, replies []mcpReply) mcpReply {
+	t.Helper()
+	if len(replies) != 1 {
+		t.Fatalf("got %d replies, want 1: %+v", len(replies), replies)
+	}
+	return replies[0]
+}
+
+func TestMCPInitialize(t *testing.T) {
+	tests := []struct {
+		params string
+		want   string
+	}{
+		{`{"protocolVersion":"2025-03-26"}`, "2025-03-26"},
+		{`{"protocolVersion":"2024-11-05"}`, "2024-11-05"},
+		{`{"protocolVersion":"1999-01-01"}`, mcpProtocolVersions[0]},
+		{`{}`, mcpProtocolVersions[0]},
+	}
+	for _, tt := range tests {
+		s := &mcpServer{cfg: &config{}, cancels: make(map[string]context.CancelFunc)}
+		r := only(t, serveMCP(t, s, `{"jsonrpc":"2.0","id":1,"method":"initialize","params":`+tt.params+`}`))
+		var result struct {
+			ProtocolVersion string `json:"protocolVersion"`
+			ServerInfo      struct {
+				Name string `json:"name"`
+			} `json:"serverInfo"`
+		}
+		if r.Error != nil || json.Unmarshal(r.Result, &result) != nil {
+			t.Fatalf("initialize with %s: %+v", tt.params, r)
+		}
+		if result.ProtocolVersion != tt.want || result.ServerInfo.Name != "ctx" {
+			t.Errorf("initialize with %s = %s, want version %s", tt.params, r.Result, tt.want)
+		}
+	}
+}
+
+func TestMCPErrors(t *testing.T) {
+	s := newTestMCPServer(t)
+	tests := []struct {
+		name, req string
+		code      int
+	}{
+		{"parse error", `{"jsonrpc":`, rpcParseError},
+		{"not JSON-RPC 2.0", `{"jsonrpc":"1.0","id":1,"method":"ping"}`, rpcInvalidRequest},
+		{"unknown method", `{"jsonrpc":"2.0","id":1,"method":"nope"}`, rpcMethodNotFound},
+		{"unknown argument", `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"echo","arguments":{"output_token_budget":5,"budget":1}}}`, rpcInvalidParams},
+		{"mistyped argument", `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"echo","arguments":{"output_token_budget":"5"}}}`, rpcInvalidParams},
+		{"arguments not an object", `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"echo","arguments":[1]}}`, rpcInvalidParams},
+		{"unknown tool", `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"nope"}}`, rpcInvalidParams},
+		{"unknown resource", `{"jsonrpc":"2.0","id":1,"method":"resources/read","params":{"uri":"ctx://plugins/nope"}}`, mcpResourceNotFound},
+		{"resource outside ctx://plugins", `{"jsonrpc":"2.0","id":1,"method":"resources/read","params":{"uri":"file:///echo"}}`, mcpResourceNotFound},
+		{"failing resource", `{"jsonrpc":"2.0","id":1,"method":"resources/read","params":{"uri":"ctx://plugins/fail"}}`, rpcInternalError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := only(t, serveMCP(t, s, tt.req))
+			if r.Error == nil || r.Error.Code != tt.code {
+				t.Errorf("reply = %s %+v, want error code %d", r.Result, r.Error, tt.code)
+			}
+		})
+	}
+}
+
+func TestMCPTools(t *testing.T) {
+	s := newTestMCPServer(t)
+	replies := serveMCP(t, s,
+		`{"jsonrpc":"2.0","id":1,"method":"tools/list"}`,
+		`{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"echo","arguments":{"output_token_budget":42}}}`,
+		`{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"fail"}}`,
+		`{"jsonrpc":"2.0","id":4,"method":"resources/read","params":{"uri":"ctx://plugins/echo"}}`,
+	)
+	byID := make(map[string]mcpReply)
+	for _, r := range replies {
+		if r.Error != nil {
+			t.Fatalf("request %s: %+v", r.ID, r.Error)
+		}
+		byID[string(r.ID)] = r
+	}
+
+	var list struct {
+		Tools []struct {
+			Name string `json:"name"`
+		} `json:"tools"`
+	}
+	json.Unmarshal(byID["1"].Result, &list)
+	var names []string
+	for _, tool := range list.Tools {
+		names = append(names, tool.Name)
+	}
+	if got := strings.Join(names, ","); got != "echo,fail,slow" {
+		t.Errorf("tools = %s, want echo,fail,slow", got)
+	}
+
+	type toolResult struct {
+		Content []struct {
+			Text string `json:"text"`
+		} `json:"content"`
+		IsError bool `json:"isError"`
+	}
+	var echo, fail toolResult
+	json.Unmarshal(byID["2"].Result, &echo)
+	if echo.IsError || len(echo.Content) != 1 || echo.Content[0].Text != `{"budget":"42"}` {
+		t.Errorf("echo tool = %s", byID["2"].Result)
+	}
+	json.Unmarshal(byID["3"].Result, &fail)
+	if !fail.IsError || len(fail.Content) != 1 || !strings.Contains(fail.Content[0].Text, "broken") {
+		t.Errorf("fail tool = %s, want an error result with its stderr", byID["3"].Result)
+	}
+	if !strings.Contains(string(byID["4"].Result), `"uri":"ctx://plugins/echo"`) {
+		t.Errorf("echo resource = %s", byID["4"].Result)
+	}
+}
+
+func TestMCPCancel(t *testing.T) {
+	s := newTestMCPServer(t)
+	start := time.Now()
+	replies := serveMCP(t, s,
+		`{"jsonrpc":"2.0","id":"slow","method":"tools/call","params":{"name":"slow"}}`,
+		`{"jsonrpc":"2.0","method":"notifications/cancelled","params":{"requestId":"slow","reason":"bored"}}`,
+		`{"jsonrpc":"2.0","id":2,"method":"ping"}`,
+	)
+	r := only(t, replies)
+	if string(r.ID) != "2" || r.Error != nil {
+		t.Errorf("reply = %+v, want only the ping's", r)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("cancelled request took %s", d)
+	}
+}
+
+func TestMCPDuplicateID(t *testing.T) {
+	s := newTestMCPServer(t)
+	replies := serveMCP(t, s,
+		`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"slow"}}`,
+		`{"jsonrpc":"2.0","id":1,"method":"ping"}`,
+		`{"jsonrpc":"2.0","method":"notifications/cancelled","params":{"requestId":1}}`,
+	)
+	// The duplicate is rejected, and cancelling still stops the first request.
+	r := only(t, replies)
+	if string(r.ID) != "1" || r.Error == nil || r.Error.Code != rpcInvalidRequest {
+		t.Errorf("reply = %+v, want an invalid request error", r)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// runRemote implements --remote: it asks the ctx serve daemon listening on
+// cfg.remote for context, or with --list-plugins for its plugins, and
+// prints the response like a local run would.
+func runRemote(cfg *config) error {
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", cfg.remote)
+		},
+	}}
+	q := url.Values{}
+	if only := append(splitList(cfg.only), cfg.selectors...); len(only) > 0 {
+		q.Set("only", strings.Join(only, ","))
+	}
+	if cfg.skip != "" {
+		q.Set("skip", cfg.skip)
+	}
+	format := cfg.outputFormat
+	if cfg.stream {
+		format = "ndjson"
+	}
+	q.Set("format", format)
+	q.Set("indent", strconv.Itoa(cfg.indent))
+	q.Set("summary", strconv.FormatBool(cfg.summary))
+	q.Set("sort", cfg.sortOrder)
+	q.Set("xml_data", cfg.xmlData)
+	if cfg.refreshCache {
+		q.Set("refresh", "true")
+	}
+	if session := os.Getenv("CTX_SESSION"); session != "" {
+		q.Set("session", session) // Nested invocations share the caller's session
+	}
+	path := contextPath
+	if cfg.listPlugins {
+		path = pluginsPath
+	}
+
+	resp, err := client.Get("http://ctx" + path + "?" + q.Encode())
+	if err != nil {
+		return fmt.Errorf("cannot reach ctx serve at %s: %w", cfg.remote, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&e) != nil || e.Error == "" {
+			e.Error = resp.Status
+		}
+		return fmt.Errorf("ctx serve: %s", e.Error)
+	}
+
+	if cfg.listPlugins {
+		var list pluginList
+		if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+			return fmt.Errorf("invalid response from ctx serve: %w", err)
+		}
+		for _, w := range list.Warnings {
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", w)
+		}
+		fmt.Printf("Plugins of ctx serve at %s:\n", cfg.remote)
+		if len(list.Plugins) == 0 {
+			fmt.Println("  (None found)")
+		}
+		for _, p := range list.Plugins {
+			fmt.Printf("  - %s\n", p.Path)
+		}
+		return nil
+	}
+
+	if _, err := io.Copy(os.Stdout, resp.Body); err != nil {
+		return fmt.Errorf("failed to read response from ctx serve: %w", err)
+	}
+	// Streamed responses carry these in trailers, available once the body is read.
+	header := func(key string) []string {
+		if v := resp.Trailer.Values(key); len(v) > 0 {
+			return v
+		}
+		return resp.Header.Values(key)
+	}
+	for _, w := range header(warningHeader) {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", w)
+	}
+	if failed, _ := strconv.Atoi(strings.Join(header(errorsHeader), "")); cfg.failOnError && failed > 0 {
+		log.Printf("%d plugin(s) failed.", failed)
+		os.Exit(exitPluginFailure)
+	}
+	return nil
+}
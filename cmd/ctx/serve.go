@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/tmc/ctx/ctxrun"
+)
+
+// ctx serve speaks HTTP over a Unix socket:
+//
+//	GET /v1/context?only=git,env&format=json
+//	GET /v1/plugins?only=tag:vcs
+//
+// /v1/context runs the selected plugins and responds with the formatted
+// output. Its query parameters mirror the flags of the same name: only,
+// skip, format (--output), indent, summary, sort and xml_data, plus session
+// (the CTX_SESSION to run in) and refresh (bypass cached results). Omitted
+// parameters take the daemon's flag values. /v1/plugins lists the plugins
+// the selection matches. Errors are JSON objects with an "error" field.
+const (
+	contextPath   = "/v1/context"
+	pluginsPath   = "/v1/plugins"
+	sessionHeader = "X-Ctx-Session"
+	errorsHeader  = "X-Ctx-Errors"  // Number of failed plugins
+	warningHeader = "X-Ctx-Warning" // One per warning
+)
+
+// contentTypes maps output formats to response content types.
+var contentTypes = map[string]string{
+	"json":     "application/json",
+	"ndjson":   "application/x-ndjson",
+	"xml":      "application/xml",
+	"markdown": "text/markdown; charset=utf-8",
+	"md":       "text/markdown; charset=utf-8",
+	"yaml":     "application/yaml",
+}
+
+// defaultSocketPath returns the socket ctx serve listens on by default.
+func defaultSocketPath() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "ctx.sock")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("ctx-%d.sock", os.Getuid()))
+}
+
+// server handles requests to ctx serve. Each request gets its own Runner,
+// built from the daemon's flags and the request's parameters, sharing one
+// in-memory result cache and one limit of -P plugin processes.
+type server struct {
+	cfg   *config
+	cache *ctxrun.ResultCache
+	limit *ctxrun.ProcessLimit
+}
+
+// runServe implements "ctx serve".
+func runServe(cfg *config) error {
+	if _, err := newRunner(cfg); err != nil { // Report bad flags before listening
+		return err
+	}
+	ln, err := listenUnix(cfg.socket)
+	if err != nil {
+		return err
+	}
+	s := &server{cfg: cfg, cache: ctxrun.NewResultCache(cfg.maxAge), limit: ctxrun.NewProcessLimit(cfg.maxParallelPlugins)}
+	mux := http.NewServeMux()
+	mux.HandleFunc(contextPath, s.handleContext)
+	mux.HandleFunc(pluginsPath, s.handlePlugins)
+	srv := &http.Server{Handler: mux}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.killGrace)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+	fmt.Fprintf(os.Stderr, "ctx serve: listening on %s\n", cfg.socket)
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// listenUnix listens on the Unix socket at path, accessible only to the
+// current user. A stale socket left by a daemon that exited uncleanly is
+// replaced; one still accepting connections is an error.
+func listenUnix(path string) (net.Listener, error) {
+	if _, err := os.Stat(path); err == nil {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use by another server", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// requestConfig returns the daemon's flags overridden by the request's
+// query parameters.
+func (s *server) requestConfig(req *http.Request) (*config, error) {
+	cfg := *s.cfg
+	q := req.URL.Query()
+	if q.Has("only") || q.Has("skip") {
+		cfg.only, cfg.skip, cfg.selectors = strings.Join(q["only"], ","), strings.Join(q["skip"], ","), nil
+	}
+	if v := q.Get("format"); v != "" {
+		cfg.outputFormat, cfg.stream = strings.ToLower(v), false
+	}
+	if v := q.Get("sort"); v != "" {
+		cfg.sortOrder = v
+	}
+	if v := q.Get("xml_data"); v != "" {
+		cfg.xmlData = v
+	}
+	var err error
+	if v := q.Get("indent"); v != "" {
+		if cfg.indent, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("invalid indent %q", v)
+		}
+	}
+	if v := q.Get("summary"); v != "" {
+		if cfg.summary, err = strconv.ParseBool(v); err != nil {
+			return nil, fmt.Errorf("invalid summary %q", v)
+		}
+	}
+	if v := q.Get("refresh"); v != "" {
+		if cfg.refreshCache, err = strconv.ParseBool(v); err != nil {
+			return nil, fmt.Errorf("invalid refresh %q", v)
+		}
+	}
+	if cfg.stream {
+		cfg.outputFormat = "ndjson"
+	}
+	return &cfg, nil
+}
+
+func (s *server) handleContext(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		httpError(w, http.StatusMethodNotAllowed, errors.New("use GET"))
+		return
+	}
+	cfg, err := s.requestConfig(req)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err)
+		return
+	}
+	extra := []ctxrun.Option{ctxrun.WithResultCache(s.cache), ctxrun.WithProcessLimit(s.limit), ctxrun.WithSessionID(req.URL.Query().Get("session"))}
+	var stream *ctxrun.NDJSONWriter
+	if cfg.outputFormat == "ndjson" {
+		stream = ctxrun.NewNDJSONWriter(flushWriter{w})
+		extra = append(extra, ctxrun.WithResultHandler(stream.Outcome))
+		w.Header().Set("Trailer", strings.Join([]string{sessionHeader, errorsHeader, warningHeader}, ", "))
+		w.Header().Set("Content-Type", contentTypes["ndjson"])
+	}
+	runner, err := newRunner(cfg, extra...)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err)
+		return
+	}
+	start := time.Now()
+	res, err := runner.Run(req.Context())
+	switch {
+	case stream != nil && res != nil:
+		stream.Summary(res) // Plugin lines are already sent; trailers follow
+	case errors.Is(err, ctxrun.ErrBudgetExceeded):
+		httpError(w, http.StatusUnprocessableEntity, err)
+		return
+	case err != nil:
+		httpError(w, http.StatusInternalServerError, err)
+		return
+	}
+	log.Printf("ctx serve: %s %s: %d plugin(s), %d failed, %s", req.Method, req.URL, len(res.Plugins), len(res.Errors), time.Since(start).Round(time.Millisecond))
+
+	setResultHeaders(w.Header(), res)
+	if stream != nil {
+		return
+	}
+	output, err := ctxrun.Format(res, formatOptions(cfg))
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err)
+		return
+	}
+	contentType, ok := contentTypes[cfg.outputFormat]
+	if !ok {
+		contentType = "text/plain; charset=utf-8"
+	}
+	w.Header().Set("Content-Type", contentType)
+	fmt.Fprint(w, output)
+}
+
+// setResultHeaders records res's session, failure count and warnings.
+func setResultHeaders(h http.Header, res *ctxrun.Result) {
+	h.Set(sessionHeader, res.SessionID)
+	h.Set(errorsHeader, strconv.Itoa(len(res.Errors)))
+	for _, warning := range res.Warnings {
+		h.Add(warningHeader, warning)
+	}
+}
+
+// pluginInfo describes a plugin in /v1/plugins responses.
+type pluginInfo struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+// pluginList is the /v1/plugins response.
+type pluginList struct {
+	Plugins  []pluginInfo `json:"plugins"`
+	Warnings []string     `json:"warnings,omitempty"`
+}
+
+func (s *server) handlePlugins(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		httpError(w, http.StatusMethodNotAllowed, errors.New("use GET"))
+		return
+	}
+	cfg, err := s.requestConfig(req)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err)
+		return
+	}
+	runner, err := newRunner(cfg, ctxrun.WithProcessLimit(s.limit))
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err)
+		return
+	}
+	paths, warnings, err := runner.Discover()
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, err)
+		return
+	}
+	paths, selectWarnings, err := runner.Select(req.Context(), paths)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err)
+		return
+	}
+	list := pluginList{Plugins: []pluginInfo{}, Warnings: append(warnings, selectWarnings...)}
+	for _, p := range paths {
+		list.Plugins = append(list.Plugins, pluginInfo{Name: ctxrun.PluginName(p), Path: p})
+	}
+	w.Header().Set("Content-Type", contentTypes["json"])
+	json.NewEncoder(w).Encode(list)
+}
+
+// httpError writes err as a JSON error response.
+func httpError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", contentTypes["json"])
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
+
+// flushWriter flushes each write to the client, so streamed NDJSON lines
+// arrive as plugins finish.
+type flushWriter struct {
+	w http.ResponseWriter
+}
+
+func (f flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if fl, ok := f.w.(http.Flusher); ok {
+		fl.Flush()
+	}
+	return n, err
+}
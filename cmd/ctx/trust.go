@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tmc/ctx/ctxrun"
+)
+
+// loadTrust resolves the trust file and policy from flags. With no explicit
+// policy, an existing trust file is enforced and a missing one disables
+// verification.
+func loadTrust(cfg *config) (*ctxrun.TrustFile, ctxrun.TrustPolicy, error) {
+	tf, err := ctxrun.LoadTrustFile(cfg.trustFile)
+	missing := errors.Is(err, fs.ErrNotExist) || cfg.trustFile == ""
+	if err != nil && !missing {
+		return nil, "", err
+	}
+	if cfg.trustPolicy == "" {
+		if missing {
+			return nil, ctxrun.TrustOff, nil
+		}
+		return tf, ctxrun.TrustEnforce, nil
+	}
+	policy, err := ctxrun.ParseTrustPolicy(cfg.trustPolicy)
+	if err != nil {
+		return nil, "", err
+	}
+	if missing && policy == ctxrun.TrustEnforce {
+		return nil, "", fmt.Errorf("--trust-policy=enforce requires a trust file (%s not found); record plugins with 'ctx trust'", cfg.trustFile)
+	}
+	return tf, policy, nil
+}
+
+// runTrust implements the "ctx trust <plugin>..." subcommand, recording the
+// SHA256 of each named plugin in the trust file.
+func runTrust(args []string) error {
+	flags := flag.NewFlagSet("ctx trust", flag.ContinueOnError)
+	trustFile := flags.String("trust-file", ctxrun.DefaultTrustFilePath(), "Trust file to update")
+	version := flags.String("version-constraint", "", "Version constraint to record, e.g. '>=1.2.0, <2' (keeps the existing one if empty)")
+	var pluginDirs listFlag
+	flags.Var(&pluginDirs, "plugin-dir", "Directory to search for ctx-* plugins first, as in a normal run (repeatable)")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: ctx trust [flags] <plugin>...\n\nRecords the SHA256 of each plugin (name like 'git' or 'ctx-git', or a path) in the trust file.\n\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return errors.New("no plugins given")
+	}
+	if *trustFile == "" {
+		return errors.New("no trust file location; use --trust-file")
+	}
+	if *version != "" {
+		if _, err := ctxrun.ParseVersionConstraint(*version); err != nil {
+			return fmt.Errorf("invalid --version-constraint: %w", err)
+		}
+	}
+
+	tf, err := ctxrun.LoadTrustFile(*trustFile)
+	if errors.Is(err, os.ErrNotExist) {
+		tf, err = &ctxrun.TrustFile{}, nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, arg := range flags.Args() {
+		path, err := resolvePlugin(arg, pluginDirs)
+		if err != nil {
+			return err
+		}
+		sum, err := ctxrun.HashFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to hash %s: %w", path, err)
+		}
+		name := filepath.Base(path)
+		tf.Set(name, sum)
+		if *version != "" {
+			for i := range tf.Plugins {
+				if tf.Plugins[i].Name == name {
+					tf.Plugins[i].Version = *version
+				}
+			}
+		}
+		fmt.Printf("Trusted %s (%s) sha256:%s\n", name, path, sum)
+	}
+	return tf.Save(*trustFile)
+}
+
+// resolvePlugin maps a plugin name or path to an executable path, searching
+// the plugin directories a run with pluginDirs would search for bare names.
+func resolvePlugin(arg string, pluginDirs []string) (string, error) {
+	if strings.ContainsRune(arg, filepath.Separator) {
+		return filepath.Abs(arg)
+	}
+	name := arg
+	if !strings.HasPrefix(name, "ctx-") {
+		name = "ctx-" + name
+	}
+	plugins, _, err := ctxrun.New(ctxrun.WithPluginDirs(pluginDirs...)).Discover()
+	if err != nil {
+		return "", err
+	}
+	for _, p := range plugins {
+		if filepath.Base(p) == name {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("plugin %q not found in plugin directories or PATH", name)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/tmc/ctx/ctxrun"
+)
+
+// runWatch implements "ctx watch". It runs the plugins, then re-runs them
+// on an interval or when files change until interrupted, writing NDJSON:
+// the first run in full, later runs only as changes, each followed by a
+// summary line.
+func runWatch(cfg *config) error {
+	if cfg.watchInterval <= 0 && cfg.watchDir == "" {
+		return errors.New("ctx watch needs --interval or --watch-dir")
+	}
+	runner, err := newRunner(cfg)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	out := ctxrun.NewNDJSONWriter(os.Stdout)
+	opts := ctxrun.WatchOptions{Interval: cfg.watchInterval, Dir: cfg.watchDir, Poll: cfg.watchPoll}
+	return runner.Watch(ctx, opts, func(res *ctxrun.Result, changes []ctxrun.Change) error {
+		out.Changes(changes)
+		return out.Summary(res)
+	})
+}
@@ -0,0 +1,93 @@
+package ctxrun
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"time"
+)
+
+const approvedEnvKey = "CTX_APPROVED"
+
+// ApprovalRequest describes a plugin's request for user approval.
+type ApprovalRequest struct {
+	Path    string // Full path of the plugin executable
+	Name    string // Name reported by the plugin
+	Version string // Version reported by the plugin
+	Message string // Human-readable "message" field from requires_approval, if any
+	// Details is the raw requires_approval object.
+	Details json.RawMessage
+}
+
+// An Approver decides whether a plugin that returned requires_approval may be
+// re-run with CTX_APPROVED=true. Calls are serialized by the Runner.
+type Approver interface {
+	Approve(ctx context.Context, req ApprovalRequest) (bool, error)
+}
+
+// ApproverFunc adapts an ordinary function to the Approver interface.
+type ApproverFunc func(ctx context.Context, req ApprovalRequest) (bool, error)
+
+// Approve calls f(ctx, req).
+func (f ApproverFunc) Approve(ctx context.Context, req ApprovalRequest) (bool, error) {
+	return f(ctx, req)
+}
+
+var (
+	// ApproveAll approves every request.
+	ApproveAll Approver = ApproverFunc(func(context.Context, ApprovalRequest) (bool, error) { return true, nil })
+	// DenyAll denies every request. It is the default Approver.
+	DenyAll Approver = ApproverFunc(func(context.Context, ApprovalRequest) (bool, error) { return false, nil })
+)
+
+// runApproved asks the Approver about a plugin that returned
+// requires_approval and, if approved, re-runs only that plugin with
+// CTX_APPROVED=true.
+func (r *Runner) runApproved(ctx context.Context, pluginPath string, pluginEnv []string, pending PluginData) (PluginData, *PluginError) {
+	execName := filepath.Base(pluginPath)
+	req := ApprovalRequest{
+		Path:    pluginPath,
+		Name:    pending.Name,
+		Version: pending.Version,
+		Details: pending.RequiresApproval,
+	}
+	var body struct {
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(pending.RequiresApproval, &body); err == nil {
+		req.Message = body.Message
+	}
+
+	start := time.Now()
+	r.approvalMu.Lock()
+	approved, err := r.approver.Approve(ctx, req)
+	r.approvalMu.Unlock()
+	if err != nil || !approved {
+		msg := "approval denied"
+		if err != nil {
+			msg = fmt.Sprintf("approval failed: %v", err)
+		}
+		r.logf("[%s] %s.", execName, msg)
+		return PluginData{}, &PluginError{
+			Path:     pluginPath,
+			Kind:     ErrorKindDenied,
+			Message:  msg,
+			Duration: time.Since(start),
+		}
+	}
+
+	r.logf("[%s] Approved; re-running with %s=true.", execName, approvedEnvKey)
+	approvedEnv := append(pluginEnv[:len(pluginEnv):len(pluginEnv)], approvedEnvKey+"=true")
+	data, attempts, perr := r.execWithRetry(ctx, pluginPath, approvedEnv)
+	data.attempts = attempts
+	if perr == nil && data.Data == nil {
+		return PluginData{}, &PluginError{
+			Path:    pluginPath,
+			Kind:    ErrorKindValidation,
+			Message: "plugin still requires approval after " + approvedEnvKey + "=true",
+		}
+	}
+	data.approved = perr == nil
+	return data, perr
+}
@@ -0,0 +1,385 @@
+package ctxrun
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrBudgetExceeded is returned by Run and Execute under BudgetFail when
+// plugin output exceeds the token or cost budget.
+var ErrBudgetExceeded = errors.New("budget exceeded")
+
+// BudgetPolicy controls what happens when plugin output exceeds the output
+// token budget or the cost budget.
+type BudgetPolicy string
+
+const (
+	BudgetNone     BudgetPolicy = "none"     // Only report; budgets are advisory
+	BudgetTruncate BudgetPolicy = "truncate" // Shorten data of the plugin that overflows, drop the rest
+	BudgetDrop     BudgetPolicy = "drop"     // Drop lowest-priority plugins until within budget
+	BudgetFail     BudgetPolicy = "fail"     // Fail the run with ErrBudgetExceeded
+)
+
+// ParseBudgetPolicy parses a BudgetPolicy name.
+func ParseBudgetPolicy(s string) (BudgetPolicy, error) {
+	switch p := BudgetPolicy(strings.ToLower(s)); p {
+	case BudgetNone, BudgetTruncate, BudgetDrop, BudgetFail:
+		return p, nil
+	}
+	return "", fmt.Errorf("invalid budget policy %q (want none, truncate, drop or fail)", s)
+}
+
+// A Tokenizer estimates how many tokens a piece of plugin data will consume.
+type Tokenizer interface {
+	CountTokens(data []byte) int
+}
+
+// TokenizerFunc adapts an ordinary function to the Tokenizer interface.
+type TokenizerFunc func(data []byte) int
+
+// CountTokens calls f(data).
+func (f TokenizerFunc) CountTokens(data []byte) int { return f(data) }
+
+// DefaultTokenizer estimates one token per four bytes, rounding up.
+var DefaultTokenizer Tokenizer = TokenizerFunc(func(data []byte) int {
+	return (len(data) + 3) / 4
+})
+
+// BudgetReport records how plugin output measured against the budgets and
+// what the policy cut.
+type BudgetReport struct {
+	Policy          BudgetPolicy   `json:"policy"`
+	TokenBudget     int            `json:"token_budget,omitempty"`
+	Tokens          int            `json:"tokens"` // Tokens in the final output
+	CostBudgetCents int            `json:"cost_budget_cents,omitempty"`
+	CostCents       int            `json:"cost_cents"` // Reported cost of the final output
+	PluginTokens    map[string]int `json:"plugin_tokens"`
+	Exceeded        bool           `json:"exceeded"` // Budget was exceeded before applying the policy
+	Dropped         []string       `json:"dropped,omitempty"`
+	Truncated       []string       `json:"truncated,omitempty"`
+}
+
+// describe summarizes usage against whichever budgets are set.
+func (b *BudgetReport) describe() string {
+	var parts []string
+	if b.TokenBudget > 0 {
+		parts = append(parts, fmt.Sprintf("%d tokens (budget %d)", b.Tokens, b.TokenBudget))
+	}
+	if b.CostBudgetCents > 0 {
+		parts = append(parts, fmt.Sprintf("%d cents (budget %d)", b.CostCents, b.CostBudgetCents))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// pluginTokens returns a plugin's token count, preferring its reported
+// output_token_count over the tokenizer's estimate.
+func (r *Runner) pluginTokens(p PluginData) int {
+	if p.Metrics != nil && p.Metrics.OutputTokenCount > 0 {
+		return p.Metrics.OutputTokenCount
+	}
+	return r.tokenizer.CountTokens(p.Data)
+}
+
+// byPriority returns plugin names ordered from highest to lowest priority,
+// breaking ties by name.
+func (r *Runner) byPriority(plugins map[string]PluginData) []string {
+	return pluginOrder(plugins, r.priority)
+}
+
+// pluginOrder returns plugin names ordered from highest to lowest priority,
+// breaking ties by name; with no priorities the order is alphabetical.
+func pluginOrder(plugins map[string]PluginData, priority map[string]int) []string {
+	names := make([]string, 0, len(plugins))
+	for name := range plugins {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		pi, pj := priority[names[i]], priority[names[j]]
+		if pi != pj {
+			return pi > pj
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+// applyBudget measures res against the configured budgets and applies the
+// budget policy, recording the outcome in res.Budget.
+func (r *Runner) applyBudget(res *Result) error {
+	if r.outputTokenBudget <= 0 && r.costBudgetCents <= 0 {
+		return nil
+	}
+	report := &BudgetReport{
+		Policy:          r.budgetPolicy,
+		TokenBudget:     r.outputTokenBudget,
+		CostBudgetCents: r.costBudgetCents,
+		PluginTokens:    make(map[string]int, len(res.Plugins)),
+	}
+	res.Budget = report
+
+	cost := func(name string) int {
+		if m := res.Plugins[name].Metrics; m != nil {
+			return m.CostEstimateCents
+		}
+		return 0
+	}
+	order := r.byPriority(res.Plugins)
+	for _, name := range order {
+		tokens := r.pluginTokens(res.Plugins[name])
+		report.PluginTokens[name] = tokens
+		report.Tokens += tokens
+		report.CostCents += cost(name)
+	}
+	overTokens := func() bool { return r.outputTokenBudget > 0 && report.Tokens > r.outputTokenBudget }
+	overCost := func() bool { return r.costBudgetCents > 0 && report.CostCents > r.costBudgetCents }
+	report.Exceeded = overTokens() || overCost()
+	if !report.Exceeded {
+		return nil
+	}
+	usage := report.describe()
+	r.logf("Budget exceeded: %s.", usage)
+
+	drop := func(name string) {
+		report.Tokens -= report.PluginTokens[name]
+		report.CostCents -= cost(name)
+		delete(report.PluginTokens, name)
+		delete(res.Plugins, name)
+		delete(res.Paths, name)
+		report.Dropped = append(report.Dropped, name)
+	}
+
+	switch r.budgetPolicy {
+	case BudgetFail:
+		return fmt.Errorf("%w: %s", ErrBudgetExceeded, usage)
+	case BudgetDrop:
+		for i := len(order) - 1; i >= 0 && (overTokens() || overCost()); i-- {
+			if overTokens() || cost(order[i]) > 0 {
+				drop(order[i])
+			}
+		}
+	case BudgetTruncate:
+		// Cost cannot be truncated; shed the lowest-priority spenders first.
+		for i := len(order) - 1; i >= 0 && overCost(); i-- {
+			if cost(order[i]) > 0 {
+				drop(order[i])
+			}
+		}
+		if r.outputTokenBudget <= 0 {
+			break
+		}
+		remaining := r.outputTokenBudget
+		for _, name := range order {
+			p, ok := res.Plugins[name]
+			if !ok {
+				continue
+			}
+			tokens := report.PluginTokens[name]
+			if tokens <= remaining {
+				remaining -= tokens
+				continue
+			}
+			p, newTokens, ok := r.truncatePlugin(p, tokens, remaining)
+			if !ok {
+				drop(name)
+				continue
+			}
+			res.Plugins[name] = p
+			report.Tokens += newTokens - tokens
+			report.PluginTokens[name] = newTokens
+			report.Truncated = append(report.Truncated, name)
+			remaining -= newTokens
+		}
+	default:
+		res.Warnings = append(res.Warnings, "budget exceeded: "+usage)
+	}
+	sort.Strings(report.Dropped)
+	sort.Strings(report.Truncated)
+	return nil
+}
+
+// truncatePlugin shortens a plugin's data from tokens to at most maxTokens,
+// measured as by pluginTokens. A reported output_token_count is scaled to
+// the tokenizer's units for truncation and back, and the plugin's metrics
+// are updated to describe the truncated data, so usage and the budget
+// report agree.
+func (r *Runner) truncatePlugin(p PluginData, tokens, maxTokens int) (PluginData, int, bool) {
+	reported := p.Metrics != nil && p.Metrics.OutputTokenCount > 0
+	estimate := r.tokenizer.CountTokens(p.Data)
+	limit := maxTokens
+	if reported && estimate > 0 {
+		limit = maxTokens * estimate / tokens
+	}
+	data, newTokens, ok := r.truncateData(p.Data, limit)
+	if !ok {
+		return p, 0, false
+	}
+	if reported && estimate > 0 {
+		newTokens = (newTokens*tokens + estimate - 1) / estimate
+	}
+	p.Data = data
+	if p.Metrics != nil {
+		m := *p.Metrics // Shared with cached results
+		if reported {
+			m.OutputTokenCount = newTokens
+		}
+		if m.OutputSizeBytes > 0 {
+			m.OutputSizeBytes = len(data)
+		}
+		p.Metrics = &m
+	}
+	return p, newTokens, true
+}
+
+// truncateData shortens JSON data to fit within maxTokens while keeping it
+// valid and structurally intact: long strings are cut and long arrays lose
+// their tail, using the largest uniform limit that fits. It reports false if
+// even the most aggressive truncation does not fit.
+func (r *Runner) truncateData(data json.RawMessage, maxTokens int) (json.RawMessage, int, bool) {
+	if maxTokens <= 0 {
+		return nil, 0, false
+	}
+	v, err := decodeOrdered(data)
+	if err != nil {
+		return nil, 0, false
+	}
+	fit := func(limit int) (json.RawMessage, int, bool) {
+		b, err := json.Marshal(shrinkJSON(v, limit))
+		if err != nil {
+			return nil, 0, false
+		}
+		tokens := r.tokenizer.CountTokens(b)
+		return b, tokens, tokens <= maxTokens
+	}
+	best, bestTokens, ok := fit(0)
+	if !ok {
+		return nil, 0, false
+	}
+	lo, hi := 0, len(data) // No string or array is longer than the whole document
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if b, tokens, ok := fit(mid); ok {
+			best, bestTokens, lo = b, tokens, mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	return best, bestTokens, true
+}
+
+// shrinkJSON returns a copy of v with strings cut to limit runes and arrays
+// cut to limit elements.
+func shrinkJSON(v any, limit int) any {
+	switch t := v.(type) {
+	case string:
+		if utf8.RuneCountInString(t) <= limit {
+			return t
+		}
+		return string([]rune(t)[:limit]) + "…"
+	case []any:
+		if len(t) > limit {
+			t = t[:limit]
+		}
+		out := make([]any, len(t))
+		for i, e := range t {
+			out[i] = shrinkJSON(e, limit)
+		}
+		return out
+	case jsonObject:
+		out := make(jsonObject, len(t))
+		for i, f := range t {
+			out[i] = jsonField{key: f.key, value: shrinkJSON(f.value, limit)}
+		}
+		return out
+	}
+	return v
+}
+
+// jsonObject is a decoded JSON object that keeps its keys in document
+// order, so truncated plugin data is not reordered.
+type jsonObject []jsonField
+
+type jsonField struct {
+	key   string
+	value any
+}
+
+func (o jsonObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, f := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, err := json.Marshal(f.key)
+		if err != nil {
+			return nil, err
+		}
+		v, err := json.Marshal(f.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// decodeOrdered decodes a single JSON value like json.Unmarshal into an
+// interface, except that objects become jsonObjects and numbers are kept
+// as json.Numbers.
+func decodeOrdered(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	v, err := decodeValue(dec)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("invalid JSON: trailing data")
+	}
+	return v, nil
+}
+
+func decodeValue(dec *json.Decoder) (any, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case json.Delim('{'):
+		obj := jsonObject{}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			v, err := decodeValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			obj = append(obj, jsonField{key: key.(string), value: v})
+		}
+		_, err := dec.Token() // '}'
+		return obj, err
+	case json.Delim('['):
+		arr := []any{}
+		for dec.More() {
+			v, err := decodeValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+		_, err := dec.Token() // ']'
+		return arr, err
+	}
+	return tok, nil
+}
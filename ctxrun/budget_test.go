@@ -0,0 +1,231 @@
+package ctxrun
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// byteTokenizer counts one token per byte, so expected sizes are exact.
+var byteTokenizer = TokenizerFunc(func(data []byte) int { return len(data) })
+
+func TestTruncateData(t *testing.T) {
+	long := `"` + strings.Repeat("a", 100) + `"`
+	tests := []struct {
+		name      string
+		data      string
+		maxTokens int
+		want      string // Empty means truncation fails
+	}{
+		{"string cut to fit", long, 20, `"aaaaaaaaaaaaaaa…"`},
+		{"one token less", long, 19, `"aaaaaaaaaaaaaa…"`},
+		{"smallest fit", long, 5, `"…"`},
+		{"too small for any fit", long, 4, ""},
+		{"zero budget", long, 0, ""},
+		{"already fits", `"abc"`, 10, `"abc"`},
+		{"array tail dropped", `[1,2,3,4,5,6,7,8,9,10]`, 9, `[1,2,3,4]`},
+		{"key order kept", `{"z":"abcdefghij","a":[1,2,3]}`, 25, `{"z":"ab…","a":[1,2]}`},
+		{"numbers kept exactly", `{"n":12345678901234567890,"s":"xxxxxxxxxx"}`, 40, `{"n":12345678901234567890,"s":"xxxx…"}`},
+		{"nested", `{"b":{"y":[{"x":"long string"}]},"a":null}`, 38, `{"b":{"y":[{"x":"long…"}]},"a":null}`},
+		{"invalid JSON", `{"a":`, 100, ""},
+		{"trailing data", `1 2`, 100, ""},
+	}
+	r := New(WithTokenizer(byteTokenizer))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, tokens, ok := r.truncateData(json.RawMessage(tt.data), tt.maxTokens)
+			if tt.want == "" {
+				if ok {
+					t.Errorf("truncateData = %s, want failure", got)
+				}
+				return
+			}
+			if !ok || string(got) != tt.want {
+				t.Fatalf("truncateData = %s, %v; want %s", got, ok, tt.want)
+			}
+			if tokens != len(got) || tokens > tt.maxTokens {
+				t.Errorf("tokens = %d for %d bytes, budget %d", tokens, len(got), tt.maxTokens)
+			}
+		})
+	}
+}
+
+func TestTruncatePluginScalesReportedTokens(t *testing.T) {
+	r := New(WithTokenizer(byteTokenizer))
+	data := json.RawMessage(`"` + strings.Repeat("a", 100) + `"`) // 102 bytes
+	metrics := &Metrics{OutputTokenCount: 51, OutputSizeBytes: 102, CostEstimateCents: 3}
+	p := PluginData{Name: "p", Data: data, Metrics: metrics}
+
+	got, tokens, ok := r.truncatePlugin(p, 51, 10)
+	if !ok {
+		t.Fatal("truncatePlugin failed")
+	}
+	// Half a reported token per byte: 10 reported tokens allow 20 bytes.
+	if want := `"aaaaaaaaaaaaaaa…"`; string(got.Data) != want {
+		t.Errorf("data = %s, want %s", got.Data, want)
+	}
+	if tokens != 10 {
+		t.Errorf("tokens = %d, want 10", tokens)
+	}
+	want := Metrics{OutputTokenCount: 10, OutputSizeBytes: 20, CostEstimateCents: 3}
+	if !reflect.DeepEqual(*got.Metrics, want) {
+		t.Errorf("metrics = %+v, want %+v", *got.Metrics, want)
+	}
+	if metrics.OutputTokenCount != 51 || metrics.OutputSizeBytes != 102 {
+		t.Errorf("original metrics modified: %+v", *metrics)
+	}
+
+	// Without a reported count the tokenizer's estimate is used as is.
+	got, tokens, ok = r.truncatePlugin(PluginData{Name: "p", Data: data}, 102, 10)
+	if !ok || tokens != 10 || string(got.Data) != `"aaaaa…"` || got.Metrics != nil {
+		t.Errorf("truncatePlugin without metrics = %s (%d tokens, metrics %v), %v", got.Data, tokens, got.Metrics, ok)
+	}
+}
+
+func TestApplyBudget(t *testing.T) {
+	str := func(n int) string { return `"` + strings.Repeat("x", n-2) + `"` } // n bytes of JSON
+	plugin := func(data string, costCents int) PluginData {
+		p := PluginData{Data: json.RawMessage(data)}
+		if costCents > 0 {
+			p.Metrics = &Metrics{CostEstimateCents: costCents}
+		}
+		return p
+	}
+	tests := []struct {
+		name      string
+		opts      []Option
+		plugins   map[string]PluginData
+		kept      []string
+		dropped   []string
+		truncated []string
+		tokens    int
+		cost      int
+		exceeded  bool
+		err       error
+		warning   bool
+	}{
+		{
+			name:    "within budget",
+			opts:    []Option{WithOutputTokenBudget(30), WithBudgetPolicy(BudgetDrop)},
+			plugins: map[string]PluginData{"a": plugin(str(10), 0), "b": plugin(str(10), 0)},
+			kept:    []string{"a", "b"},
+			tokens:  20,
+		},
+		{
+			name:     "drop by name",
+			opts:     []Option{WithOutputTokenBudget(20), WithBudgetPolicy(BudgetDrop)},
+			plugins:  map[string]PluginData{"a": plugin(str(10), 0), "b": plugin(str(10), 0), "c": plugin(str(10), 0)},
+			kept:     []string{"a", "b"},
+			dropped:  []string{"c"},
+			tokens:   20,
+			exceeded: true,
+		},
+		{
+			name:     "drop by priority then name",
+			opts:     []Option{WithOutputTokenBudget(10), WithBudgetPolicy(BudgetDrop), WithPriorities(map[string]int{"c": 1, "a": -1})},
+			plugins:  map[string]PluginData{"a": plugin(str(10), 0), "b": plugin(str(10), 0), "c": plugin(str(10), 0), "d": plugin(str(10), 0)},
+			kept:     []string{"c"},
+			dropped:  []string{"a", "b", "d"},
+			tokens:   10,
+			exceeded: true,
+		},
+		{
+			name:     "drop for cost skips free plugins",
+			opts:     []Option{WithCostBudget(5), WithBudgetPolicy(BudgetDrop)},
+			plugins:  map[string]PluginData{"a": plugin(str(10), 3), "b": plugin(str(10), 3), "c": plugin(str(10), 0)},
+			kept:     []string{"a", "c"},
+			dropped:  []string{"b"},
+			tokens:   20,
+			cost:     3,
+			exceeded: true,
+		},
+		{
+			name:     "truncate sheds cost by priority",
+			opts:     []Option{WithCostBudget(5), WithBudgetPolicy(BudgetTruncate), WithPriorities(map[string]int{"b": 1})},
+			plugins:  map[string]PluginData{"a": plugin(str(10), 3), "b": plugin(str(10), 3), "c": plugin(str(10), 0)},
+			kept:     []string{"b", "c"},
+			dropped:  []string{"a"},
+			tokens:   20,
+			cost:     3,
+			exceeded: true,
+		},
+		{
+			name:      "truncate the overflowing plugin and drop the rest",
+			opts:      []Option{WithOutputTokenBudget(30), WithBudgetPolicy(BudgetTruncate)},
+			plugins:   map[string]PluginData{"a": plugin(str(22), 0), "b": plugin(str(22), 0), "c": plugin(str(22), 0)},
+			kept:      []string{"a", "b"},
+			dropped:   []string{"c"},
+			truncated: []string{"b"},
+			tokens:    30,
+			exceeded:  true,
+		},
+		{
+			name:     "fail",
+			opts:     []Option{WithOutputTokenBudget(10), WithBudgetPolicy(BudgetFail)},
+			plugins:  map[string]PluginData{"a": plugin(str(10), 0), "b": plugin(str(10), 0)},
+			kept:     []string{"a", "b"},
+			tokens:   20,
+			exceeded: true,
+			err:      ErrBudgetExceeded,
+		},
+		{
+			name:     "none only warns",
+			opts:     []Option{WithCostBudget(1)},
+			plugins:  map[string]PluginData{"a": plugin(str(10), 2)},
+			kept:     []string{"a"},
+			tokens:   10,
+			cost:     2,
+			exceeded: true,
+			warning:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := New(append([]Option{WithTokenizer(byteTokenizer)}, tt.opts...)...)
+			res := &Result{Plugins: tt.plugins, Paths: map[string]string{}}
+			for name := range tt.plugins {
+				res.Paths[name] = "/bin/ctx-" + name
+			}
+			err := r.applyBudget(res)
+			if !errors.Is(err, tt.err) || (err == nil) != (tt.err == nil) {
+				t.Fatalf("applyBudget error = %v, want %v", err, tt.err)
+			}
+			var kept, paths []string
+			for name := range res.Plugins {
+				kept = append(kept, name)
+			}
+			for name := range res.Paths {
+				paths = append(paths, name)
+			}
+			sort.Strings(kept)
+			sort.Strings(paths)
+			if !reflect.DeepEqual(kept, tt.kept) || !reflect.DeepEqual(paths, tt.kept) {
+				t.Errorf("kept plugins %v with paths %v, want %v", kept, paths, tt.kept)
+			}
+			b := res.Budget
+			if !reflect.DeepEqual(b.Dropped, tt.dropped) || !reflect.DeepEqual(b.Truncated, tt.truncated) {
+				t.Errorf("dropped %v, truncated %v; want %v, %v", b.Dropped, b.Truncated, tt.dropped, tt.truncated)
+			}
+			if b.Tokens != tt.tokens || b.CostCents != tt.cost || b.Exceeded != tt.exceeded {
+				t.Errorf("tokens %d, cost %d, exceeded %v; want %d, %d, %v", b.Tokens, b.CostCents, b.Exceeded, tt.tokens, tt.cost, tt.exceeded)
+			}
+			sum := 0
+			for _, n := range b.PluginTokens {
+				sum += n
+			}
+			if sum != b.Tokens {
+				t.Errorf("plugin tokens %v sum to %d, report says %d", b.PluginTokens, sum, b.Tokens)
+			}
+			if got := len(res.Warnings) > 0; got != tt.warning {
+				t.Errorf("warnings = %q, want warning %v", res.Warnings, tt.warning)
+			}
+		})
+	}
+
+	if res := (&Result{Plugins: map[string]PluginData{"a": {Data: json.RawMessage(`1`)}}}); New().applyBudget(res) != nil || res.Budget != nil {
+		t.Errorf("applyBudget without budgets set a report: %+v", res.Budget)
+	}
+}
@@ -0,0 +1,202 @@
+package ctxrun
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CacheInfo is the incubating cache_info object plugins may return to mark
+// their output as reusable (see spec section 5).
+type CacheInfo struct {
+	Cacheable  bool `json:"cacheable"`
+	TTLSeconds int  `json:"ttl_seconds,omitempty"`
+}
+
+// cacheEntry is the on-disk form of a cached plugin result.
+type cacheEntry struct {
+	Path      string     `json:"path"`
+	StoredAt  time.Time  `json:"stored_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	Result    PluginData `json:"result"`
+}
+
+// volatileEnvKeys are CTX_* variables that change on every run and so must
+// not contribute to cache keys.
+var volatileEnvKeys = map[string]bool{
+	sessionEnvKey:  true,
+	shlvlEnvKey:    true,
+	approvedEnvKey: true,
+	deadlineEnvKey: true,
+}
+
+// resultCacheDir returns the directory holding cached plugin results, or ""
+// if host-side caching is disabled or no cache directory is available.
+func (r *Runner) resultCacheDir() string {
+	if r.noCache {
+		return ""
+	}
+	base := r.resolveCacheDir()
+	if base == "" {
+		return ""
+	}
+	return filepath.Join(base, "results")
+}
+
+// cacheKey derives the cache key for a plugin from its path, the SHA256 of
+// its binary, the working directory and the non-volatile part of its
+// environment: CTX_* variables and the variables named in envKeys.
+func cacheKey(pluginPath string, pluginEnv []string, envKeys []string) (string, error) {
+	binHash, err := HashFile(pluginPath)
+	if err != nil {
+		return "", err
+	}
+	wd, _ := os.Getwd()
+
+	var relevant []string
+	for _, kv := range pluginEnv {
+		key := strings.SplitN(kv, "=", 2)[0]
+		if (strings.HasPrefix(key, "CTX_") || contains(envKeys, key)) && !volatileEnvKeys[key] {
+			relevant = append(relevant, kv)
+		}
+	}
+	sort.Strings(relevant)
+
+	h := sha256.New()
+	fmt.Fprintf(h, "path=%s\nbinary=%s\nwd=%s\n", pluginPath, binHash, wd)
+	for _, kv := range relevant {
+		fmt.Fprintf(h, "env=%s\n", kv)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// cacheEnvKeys returns the variables besides CTX_* that a plugin's result
+// depends on: those set for it in its PluginConfig, and those listed as
+// supported in its capability document, if known.
+func (r *Runner) cacheEnvKeys(pluginPath string, caps *Capabilities) []string {
+	var keys []string
+	for k := range r.pluginConfig[PluginName(pluginPath)].Env {
+		keys = append(keys, k)
+	}
+	if caps != nil {
+		keys = append(keys, caps.SupportedEnv...)
+	}
+	return keys
+}
+
+// HashFile returns the hex-encoded SHA256 of the file at path, as recorded
+// in trust files.
+func HashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// cachedRunPlugin serves a plugin's result from the in-memory ResultCache
+// or the host-side cache when a fresh entry exists, and otherwise runs it
+// and stores the result where allowed. The boolean result reports whether
+// the data came from a cache. caps is the plugin's capability document, or
+// nil if it was not queried.
+func (r *Runner) cachedRunPlugin(ctx context.Context, pluginPath string, pluginEnv []string, caps *Capabilities) (PluginData, bool, *PluginError) {
+	dir := r.resultCacheDir()
+	if dir == "" && r.resultCache == nil {
+		data, perr := r.runPlugin(ctx, pluginPath, pluginEnv)
+		return data, false, perr
+	}
+	execName := filepath.Base(pluginPath)
+	key, err := cacheKey(pluginPath, pluginEnv, r.cacheEnvKeys(pluginPath, caps))
+	if err != nil {
+		r.logf("[%s] Warning: Could not compute cache key: %v", execName, err)
+		data, perr := r.runPlugin(ctx, pluginPath, pluginEnv)
+		return data, false, perr
+	}
+	entryPath := ""
+	if dir != "" {
+		entryPath = filepath.Join(dir, key+".json")
+	}
+
+	if !r.refreshCache {
+		if data, ok := r.resultCache.get(key); ok {
+			r.logf("[%s] Memory cache hit.", execName)
+			return data, true, nil
+		}
+		if entryPath != "" {
+			if entry, ok := readCacheEntry(entryPath); ok && time.Now().Before(entry.ExpiresAt) {
+				r.logf("[%s] Cache hit (stored %s).", execName, entry.StoredAt.Format(time.RFC3339))
+				return entry.Result, true, nil
+			}
+		}
+	}
+
+	data, perr := r.runPlugin(ctx, pluginPath, pluginEnv)
+	if perr == nil {
+		r.resultCache.put(key, data)
+	}
+	if entryPath == "" || perr != nil || data.approved || data.CacheInfo == nil || !data.CacheInfo.Cacheable || data.CacheInfo.TTLSeconds <= 0 {
+		return data, false, perr
+	}
+	now := time.Now()
+	entry := cacheEntry{
+		Path:      pluginPath,
+		StoredAt:  now,
+		ExpiresAt: now.Add(time.Duration(data.CacheInfo.TTLSeconds) * time.Second),
+		Result:    data,
+	}
+	if err := writeCacheEntry(entryPath, entry); err != nil {
+		r.logf("[%s] Warning: Could not write cache entry: %v", execName, err)
+	}
+	return data, false, nil
+}
+
+// readCacheEntry loads a cache entry, reporting false if it is missing or corrupt.
+func readCacheEntry(path string) (cacheEntry, bool) {
+	var entry cacheEntry
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return entry, false
+	}
+	if err := json.Unmarshal(b, &entry); err != nil {
+		return entry, false
+	}
+	return entry, true
+}
+
+// writeCacheEntry atomically stores a cache entry.
+func writeCacheEntry(path string, entry cacheEntry) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".entry-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
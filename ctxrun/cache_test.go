@@ -0,0 +1,106 @@
+package ctxrun
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestCacheKey(t *testing.T) {
+	plugin := writePlugin(t, t.TempDir(), "ctx-echo", "echo\n")
+	other := writePlugin(t, t.TempDir(), "ctx-echo", "echo other\n")
+	base := []string{"CTX_SESSION=01A", "CTX_SHLVL=1", "CTX_OUTPUT_TOKEN_BUDGET=100", "GREETING=hi", "HOME=/home/u", "PATH=/bin"}
+	envKeys := []string{"GREETING"}
+	key := func(t *testing.T, path string, env, keys []string) string {
+		t.Helper()
+		k, err := cacheKey(path, env, keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+	want := key(t, plugin, base, envKeys)
+
+	same := []struct {
+		name string
+		env  []string
+	}{
+		{"other session", replaceEnv(base, "CTX_SESSION=01B")},
+		{"other shell level", replaceEnv(base, "CTX_SHLVL=2")},
+		{"deadline", append(base[:len(base):len(base)], "CTX_DEADLINE_TIMESTAMP=1700000000")},
+		{"approval", append(base[:len(base):len(base)], "CTX_APPROVED=true")},
+		{"unrelated variable", replaceEnv(base, "HOME=/root")},
+		{"order", []string{"PATH=/bin", "GREETING=hi", "CTX_OUTPUT_TOKEN_BUDGET=100", "HOME=/home/u", "CTX_SHLVL=1", "CTX_SESSION=01A"}},
+	}
+	for _, tt := range same {
+		if got := key(t, plugin, tt.env, envKeys); got != want {
+			t.Errorf("%s: key changed", tt.name)
+		}
+	}
+
+	differ := []struct {
+		name string
+		path string
+		env  []string
+		keys []string
+	}{
+		{"CTX variable", plugin, replaceEnv(base, "CTX_OUTPUT_TOKEN_BUDGET=200"), envKeys},
+		{"new CTX variable", plugin, append(base[:len(base):len(base)], "CTX_MODE=fast"), envKeys},
+		{"configured or declared variable", plugin, replaceEnv(base, "GREETING=hello"), envKeys},
+		{"variable no longer relevant", plugin, base, nil},
+		{"other binary", other, base, envKeys},
+	}
+	for _, tt := range differ {
+		if got := key(t, tt.path, tt.env, tt.keys); got == want {
+			t.Errorf("%s: key unchanged", tt.name)
+		}
+	}
+}
+
+// replaceEnv returns a copy of env with the variable set by kv replaced.
+func replaceEnv(env []string, kv string) []string {
+	name, _, _ := strings.Cut(kv, "=")
+	out := make([]string, 0, len(env))
+	for _, e := range env {
+		if k, _, _ := strings.Cut(e, "="); k != name {
+			out = append(out, e)
+		}
+	}
+	return append(out, kv)
+}
+
+func TestCacheEnvKeys(t *testing.T) {
+	r := New(WithPluginConfig(map[string]PluginConfig{
+		"echo": {Env: map[string]string{"GREETING": "hi", "NAME": "x"}},
+		"git":  {Env: map[string]string{"GIT_DIR": "/repo"}},
+	}))
+	got := r.cacheEnvKeys("/bin/ctx-echo", &Capabilities{SupportedEnv: []string{"CTX_MODE", "LANG"}})
+	sort.Strings(got)
+	if want := []string{"CTX_MODE", "GREETING", "LANG", "NAME"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("cacheEnvKeys = %v, want %v", got, want)
+	}
+	if got := r.cacheEnvKeys("/bin/ctx-other", nil); len(got) != 0 {
+		t.Errorf("cacheEnvKeys for an unconfigured plugin = %v, want none", got)
+	}
+}
+
+func TestCacheKeyAcrossSessions(t *testing.T) {
+	plugin := writePlugin(t, t.TempDir(), "ctx-echo", "echo\n")
+	key := func(greeting, session string) string {
+		t.Helper()
+		r := New(WithPluginConfig(map[string]PluginConfig{"echo": {Env: map[string]string{"GREETING": greeting}}}))
+		env := r.pluginEnvFor(plugin, r.pluginEnv(session))
+		k, err := cacheKey(plugin, env, r.cacheEnvKeys(plugin, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+	if key("hi", "01A") != key("hi", "01B") {
+		t.Error("key differs between sessions")
+	}
+	if key("hi", "01A") == key("hello", "01A") {
+		t.Error("key ignores the configured plugin env")
+	}
+}
@@ -0,0 +1,131 @@
+package ctxrun
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// SpecFlag is the argument ctx passes to ask a plugin to describe itself.
+const SpecFlag = "--ctx-spec"
+
+// SpecVersion is the version of the capability document format. Plugins
+// answering SpecFlag MUST set ctx_spec so that plugins which ignore unknown
+// flags and print their normal output are not mistaken for a description.
+const SpecVersion = "0.1.0"
+
+// specTimeout bounds a single --ctx-spec invocation.
+const specTimeout = 5 * time.Second
+
+// Capabilities is the document a plugin prints when invoked with SpecFlag.
+type Capabilities struct {
+	CtxSpec      string          `json:"ctx_spec"` // Capability document version
+	Name         string          `json:"name"`
+	Version      string          `json:"version"`
+	Description  string          `json:"description,omitempty"`
+	SupportedEnv []string        `json:"supported_env,omitempty"` // CTX_* variables the plugin honors
+	Modes        []string        `json:"modes,omitempty"`         // Modes the plugin supports; empty means all
+	Tags         []string        `json:"tags,omitempty"`
+	Dependencies []string        `json:"dependencies,omitempty"` // External tools the plugin calls
+	DataSchema   json.RawMessage `json:"data_schema,omitempty"`  // JSON Schema for the data field
+}
+
+// SupportsMode reports whether the plugin supports mode. Plugins that do not
+// list any modes are assumed to support every mode, and every plugin
+// supports the empty mode.
+func (c *Capabilities) SupportsMode(mode string) bool {
+	if c == nil || len(c.Modes) == 0 || mode == "" {
+		return true
+	}
+	return contains(c.Modes, mode)
+}
+
+// specCacheEntry is the on-disk form of a cached capability document.
+// A nil Spec records that the plugin does not answer SpecFlag.
+type specCacheEntry struct {
+	Path   string        `json:"path"`
+	SHA256 string        `json:"sha256"`
+	Spec   *Capabilities `json:"spec"`
+}
+
+// Capabilities returns the capability document for a plugin, invoking it
+// with SpecFlag if no cached document exists for its current binary. It
+// returns nil, nil if the plugin does not support the handshake. Under
+// TrustEnforce, untrusted plugins are not invoked.
+func (r *Runner) Capabilities(ctx context.Context, pluginPath string) (*Capabilities, error) {
+	if _, _, perr := r.verifyTrust(pluginPath); perr != nil {
+		return nil, perr
+	}
+	sessionID, _ := r.sessionID()
+	return r.capabilities(ctx, pluginPath, r.pluginEnv(sessionID))
+}
+
+// capabilities implements Capabilities for an already verified plugin.
+func (r *Runner) capabilities(ctx context.Context, pluginPath string, pluginEnv []string) (*Capabilities, error) {
+	sum, err := HashFile(pluginPath)
+	if err != nil {
+		return nil, err
+	}
+	var cachePath string
+	if base := r.resolveCacheDir(); base != "" && !r.noCache {
+		key := sha256.Sum256([]byte(pluginPath + "\x00" + sum))
+		cachePath = filepath.Join(base, "specs", hex.EncodeToString(key[:])+".json")
+		if b, err := os.ReadFile(cachePath); err == nil && !r.refreshCache {
+			var entry specCacheEntry
+			if json.Unmarshal(b, &entry) == nil && entry.SHA256 == sum {
+				return entry.Spec, nil
+			}
+		}
+	}
+
+	spec, err := r.querySpec(ctx, pluginPath, pluginEnv)
+	if err != nil {
+		return nil, err
+	}
+	if cachePath != "" {
+		b, _ := json.Marshal(specCacheEntry{Path: pluginPath, SHA256: sum, Spec: spec})
+		if err := os.MkdirAll(filepath.Dir(cachePath), 0o700); err == nil {
+			err = os.WriteFile(cachePath, b, 0o600)
+		}
+		if err != nil {
+			r.logf("[%s] Warning: Could not cache capability document: %v", filepath.Base(pluginPath), err)
+		}
+	}
+	return spec, nil
+}
+
+// querySpec invokes a plugin with SpecFlag and decodes its answer.
+func (r *Runner) querySpec(ctx context.Context, pluginPath string, pluginEnv []string) (*Capabilities, error) {
+	execName := filepath.Base(pluginPath)
+	ctx, cancel := context.WithTimeout(ctx, specTimeout)
+	defer cancel()
+
+	release, err := r.processLimit.acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w", execName, SpecFlag, err)
+	}
+	cmd := exec.CommandContext(ctx, pluginPath, SpecFlag)
+	cmd.Env = pluginEnv
+	stdout, err := cmd.Output()
+	release()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("%s %s: %w", execName, SpecFlag, ctx.Err())
+		}
+		r.logf("[%s] No capability document (%v).", execName, err)
+		return nil, nil
+	}
+	var spec Capabilities
+	if err := json.Unmarshal(stdout, &spec); err != nil || spec.CtxSpec == "" {
+		r.logf("[%s] No capability document (plugin ignored %s).", execName, SpecFlag)
+		return nil, nil
+	}
+	r.logf("[%s] Capability document version %s.", execName, spec.CtxSpec)
+	return &spec, nil
+}
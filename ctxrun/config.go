@@ -0,0 +1,271 @@
+package ctxrun
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+// ProjectConfigName is the name of the project-local config file, looked up
+// in the working directory and its parents.
+const ProjectConfigName = ".ctx.yaml"
+
+// Config holds ctx defaults loaded from YAML config files. Zero values mean
+// unset, so that a later file or a command-line flag can take precedence.
+type Config struct {
+	Output              string   `json:"output,omitempty"` // yaml, json, xml, markdown, prompt or txtar
+	Indent              int      `json:"indent,omitempty"`
+	Parallel            int      `json:"parallel,omitempty"`
+	OutputTokenBudget   int      `json:"output_token_budget,omitempty"`
+	ThinkingTokenBudget int      `json:"thinking_token_budget,omitempty"`
+	CostBudgetCents     int      `json:"cost_budget_cents,omitempty"`
+	BudgetPolicy        string   `json:"budget_policy,omitempty"`
+	Priority            []string `json:"priority,omitempty"`   // Highest priority first
+	Sort                string   `json:"sort,omitempty"`       // Output order: name or priority
+	Duplicates          string   `json:"duplicates,omitempty"` // Duplicate name policy: first, namespace or error
+	AllowedTools        []string `json:"allowed_tools,omitempty"`
+	PluginTimeout       Duration `json:"plugin_timeout,omitempty"`
+	Timeout             Duration `json:"timeout,omitempty"`
+	PluginRetries       int      `json:"plugin_retries,omitempty"`
+	Only                []string `json:"only,omitempty"` // Plugins to run; see Runner.Select
+	Skip                []string `json:"skip,omitempty"` // Plugins not to run
+
+	// Plugins holds per-plugin settings keyed by plugin name (git or ctx-git).
+	Plugins map[string]PluginConfig `json:"plugins,omitempty"`
+}
+
+// PluginConfig holds settings for a single plugin.
+type PluginConfig struct {
+	Env     map[string]string `json:"env,omitempty"`     // Added to or overriding the plugin's environment
+	Timeout Duration          `json:"timeout,omitempty"` // Replaces the runner's plugin timeout
+}
+
+// Duration is a time.Duration written as a string such as "30s" in config
+// files.
+type Duration time.Duration
+
+// UnmarshalJSON accepts a duration string or a number of nanoseconds.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		var n int64
+		if err := json.Unmarshal(b, &n); err != nil {
+			return fmt.Errorf("invalid duration %s", b)
+		}
+		*d = Duration(n)
+		return nil
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalJSON writes the duration as a string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// DefaultConfigPath returns $XDG_CONFIG_HOME/ctx/config.yaml, or "" if no
+// configuration directory can be determined.
+func DefaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "ctx", "config.yaml")
+}
+
+// FindProjectConfig returns the path of the nearest ProjectConfigName in dir
+// or its parents, or "" if there is none.
+func FindProjectConfig(dir string) string {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	for {
+		p := filepath.Join(dir, ProjectConfigName)
+		if info, err := os.Stat(p); err == nil && !info.IsDir() {
+			return p
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// LoadConfig reads a config file. A missing file yields an error satisfying
+// errors.Is(err, fs.ErrNotExist).
+func LoadConfig(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c Config
+	if err := yaml.UnmarshalStrict(b, &c); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	plugins := make(map[string]PluginConfig, len(c.Plugins))
+	for name, pc := range c.Plugins {
+		for key := range pc.Env {
+			if err := checkEnvOverride(key); err != nil {
+				return nil, fmt.Errorf("config file %s: plugin %q: %w", path, name, err)
+			}
+		}
+		plugins[strings.TrimPrefix(name, "ctx-")] = pc
+	}
+	c.Plugins = plugins
+	return &c, nil
+}
+
+// checkEnvOverride rejects per-plugin environment keys that ctx manages
+// itself and dynamic loader variables. It is a sanity check, not a sandbox:
+// PATH, BASH_ENV and the like still change what a plugin executes, which is
+// why LoadProjectConfig does not accept env overrides at all.
+func checkEnvOverride(key string) error {
+	switch {
+	case key == "" || strings.ContainsAny(key, "=\x00"):
+		return fmt.Errorf("invalid environment variable name %q", key)
+	case volatileEnvKeys[key]:
+		return fmt.Errorf("environment variable %s is managed by ctx", key)
+	case strings.HasPrefix(key, "LD_") || strings.HasPrefix(key, "DYLD_"):
+		return fmt.Errorf("environment variable %s may not be set from a config file", key)
+	}
+	return nil
+}
+
+// LoadProjectConfig reads a project-local config file, found by
+// FindProjectConfig. Such files come with the directory ctx runs in rather
+// than from the user, so per-plugin env overrides are rejected: they could
+// point any plugin at code of the project's choosing.
+func LoadProjectConfig(path string) (*Config, error) {
+	c, err := LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	for name, pc := range c.Plugins {
+		if len(pc.Env) > 0 {
+			return nil, fmt.Errorf("config file %s: plugin %q: env may only be set in the user config file", path, name)
+		}
+	}
+	return c, nil
+}
+
+// Merge overlays the settings of o onto c: set fields of o replace those of
+// c, and per-plugin settings are merged key by key.
+func (c *Config) Merge(o *Config) {
+	if o.Output != "" {
+		c.Output = o.Output
+	}
+	if o.Indent != 0 {
+		c.Indent = o.Indent
+	}
+	if o.Parallel != 0 {
+		c.Parallel = o.Parallel
+	}
+	if o.OutputTokenBudget != 0 {
+		c.OutputTokenBudget = o.OutputTokenBudget
+	}
+	if o.ThinkingTokenBudget != 0 {
+		c.ThinkingTokenBudget = o.ThinkingTokenBudget
+	}
+	if o.CostBudgetCents != 0 {
+		c.CostBudgetCents = o.CostBudgetCents
+	}
+	if o.BudgetPolicy != "" {
+		c.BudgetPolicy = o.BudgetPolicy
+	}
+	if o.Sort != "" {
+		c.Sort = o.Sort
+	}
+	if o.Duplicates != "" {
+		c.Duplicates = o.Duplicates
+	}
+	if o.Priority != nil {
+		c.Priority = o.Priority
+	}
+	if o.AllowedTools != nil {
+		c.AllowedTools = o.AllowedTools
+	}
+	if o.PluginTimeout != 0 {
+		c.PluginTimeout = o.PluginTimeout
+	}
+	if o.Timeout != 0 {
+		c.Timeout = o.Timeout
+	}
+	if o.PluginRetries != 0 {
+		c.PluginRetries = o.PluginRetries
+	}
+	if o.Only != nil {
+		c.Only = o.Only
+	}
+	if o.Skip != nil {
+		c.Skip = o.Skip
+	}
+	for name, opc := range o.Plugins {
+		if c.Plugins == nil {
+			c.Plugins = make(map[string]PluginConfig)
+		}
+		pc := c.Plugins[name]
+		if opc.Timeout != 0 {
+			pc.Timeout = opc.Timeout
+		}
+		for k, v := range opc.Env {
+			if pc.Env == nil {
+				pc.Env = make(map[string]string)
+			}
+			pc.Env[k] = v
+		}
+		c.Plugins[name] = pc
+	}
+}
+
+// pluginTimeoutFor returns the timeout for a plugin: its configured
+// override, if any, or the runner's plugin timeout.
+func (r *Runner) pluginTimeoutFor(pluginPath string) time.Duration {
+	if pc, ok := r.pluginConfig[PluginName(pluginPath)]; ok && pc.Timeout > 0 {
+		return time.Duration(pc.Timeout)
+	}
+	return r.pluginTimeout
+}
+
+// pluginEnvFor returns the environment for a plugin: the shared environment
+// with its configured overrides applied.
+func (r *Runner) pluginEnvFor(pluginPath string, pluginEnv []string) []string {
+	pc, ok := r.pluginConfig[PluginName(pluginPath)]
+	if !ok {
+		return pluginEnv
+	}
+	vars := make(map[string]string, len(pc.Env)+1)
+	for k, v := range pc.Env {
+		vars[k] = v
+	}
+	if pc.Timeout > 0 {
+		if sec := int(time.Duration(pc.Timeout).Seconds()); sec > 0 {
+			vars["CTX_TIMEOUT_SECONDS"] = fmt.Sprint(sec)
+		}
+	}
+	if len(vars) == 0 {
+		return pluginEnv
+	}
+	env := make([]string, 0, len(pluginEnv)+len(vars))
+	for _, kv := range pluginEnv {
+		key, _, _ := strings.Cut(kv, "=")
+		if _, ok := vars[key]; !ok {
+			env = append(env, kv)
+		}
+	}
+	for k, v := range vars {
+		env = append(env, k+"="+v)
+	}
+	return env
+}
@@ -0,0 +1,149 @@
+package ctxrun
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfig writes a config file named name into dir and returns its path.
+func writeConfig(t *testing.T, dir, name, data string) string {
+	t.Helper()
+	p := filepath.Join(dir, name)
+	if err := os.WriteFile(p, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestConfigMerge(t *testing.T) {
+	user := &Config{
+		Output:        "json",
+		Indent:        4,
+		BudgetPolicy:  "drop",
+		Priority:      []string{"git"},
+		Only:          []string{"git", "env"},
+		PluginTimeout: Duration(time.Second),
+		Plugins: map[string]PluginConfig{
+			"git": {Env: map[string]string{"A": "user", "B": "user"}, Timeout: Duration(time.Second)},
+			"env": {Env: map[string]string{"C": "user"}},
+		},
+	}
+	project := &Config{
+		Output:   "xml",
+		Priority: []string{}, // Set but empty: clears the user's list
+		Skip:     []string{"env"},
+		Plugins: map[string]PluginConfig{
+			"git": {Timeout: Duration(time.Minute)},
+			"go":  {Timeout: Duration(time.Hour)},
+		},
+	}
+	other := &Config{Plugins: map[string]PluginConfig{"git": {Env: map[string]string{"B": "other"}}}}
+
+	user.Merge(project)
+	user.Merge(other)
+	want := &Config{
+		Output:        "xml",
+		Indent:        4,
+		BudgetPolicy:  "drop",
+		Priority:      []string{},
+		Only:          []string{"git", "env"},
+		Skip:          []string{"env"},
+		PluginTimeout: Duration(time.Second),
+		Plugins: map[string]PluginConfig{
+			"git": {Env: map[string]string{"A": "user", "B": "other"}, Timeout: Duration(time.Minute)},
+			"env": {Env: map[string]string{"C": "user"}},
+			"go":  {Timeout: Duration(time.Hour)},
+		},
+	}
+	if !reflect.DeepEqual(user, want) {
+		t.Errorf("merged config =\n%+v\nwant\n%+v", user, want)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := writeConfig(t, dir, "config.yaml", `
+output: json
+plugin_timeout: 30s
+timeout: 1000000000
+plugins:
+  ctx-git:
+    env: {GIT_DIR: /repo/.git}
+    timeout: 1m
+`)
+	c, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Config{
+		Output:        "json",
+		PluginTimeout: Duration(30 * time.Second),
+		Timeout:       Duration(time.Second),
+		Plugins:       map[string]PluginConfig{"git": {Env: map[string]string{"GIT_DIR": "/repo/.git"}, Timeout: Duration(time.Minute)}},
+	}
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("LoadConfig =\n%+v\nwant\n%+v", c, want)
+	}
+
+	tests := []struct {
+		name, data, want string
+	}{
+		{"unknown field", "outptu: json\n", "unknown field"},
+		{"bad duration", "timeout: soon\n", "invalid duration"},
+		{"managed variable", "plugins:\n  git:\n    env: {CTX_SESSION: x}\n", "managed by ctx"},
+		{"loader variable", "plugins:\n  git:\n    env: {LD_PRELOAD: /x.so}\n", "may not be set"},
+		{"invalid name", "plugins:\n  git:\n    env: {'A=B': x}\n", "invalid environment variable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadConfig(writeConfig(t, dir, "bad.yaml", tt.data))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("LoadConfig error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadProjectConfig(t *testing.T) {
+	dir := t.TempDir()
+	c, err := LoadProjectConfig(writeConfig(t, dir, ProjectConfigName, "output: xml\nplugins:\n  git:\n    timeout: 5s\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Output != "xml" || c.Plugins["git"].Timeout != Duration(5*time.Second) {
+		t.Errorf("LoadProjectConfig = %+v", c)
+	}
+
+	for _, env := range []string{"PATH: /tmp/evil", "BASH_ENV: /tmp/evil.sh", "GIT_DIR: .git"} {
+		path := writeConfig(t, dir, ProjectConfigName, "plugins:\n  git:\n    env: {"+env+"}\n")
+		if _, err := LoadProjectConfig(path); err == nil || !strings.Contains(err.Error(), "user config") {
+			t.Errorf("LoadProjectConfig with env %s: error = %v, want rejection", env, err)
+		}
+		if _, err := LoadConfig(path); err != nil {
+			t.Errorf("LoadConfig with env %s: %v", env, err)
+		}
+	}
+}
+
+func TestFindProjectConfig(t *testing.T) {
+	root := t.TempDir()
+	sub := filepath.Join(root, "a", "b")
+	if err := os.MkdirAll(sub, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if got := FindProjectConfig(sub); got != "" {
+		t.Fatalf("FindProjectConfig with no config = %q", got)
+	}
+	want := writeConfig(t, root, ProjectConfigName, "")
+	if got := FindProjectConfig(sub); got != want {
+		t.Errorf("FindProjectConfig = %q, want %q", got, want)
+	}
+	nearer := writeConfig(t, filepath.Join(root, "a"), ProjectConfigName, "")
+	if got := FindProjectConfig(sub); got != nearer {
+		t.Errorf("FindProjectConfig = %q, want %q", got, nearer)
+	}
+}
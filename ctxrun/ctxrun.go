@@ -0,0 +1,238 @@
+// Package ctxrun discovers and executes ctx-* plugins and aggregates their
+// structured output. It is the engine behind the ctx command and can be
+// embedded directly by Go programs that want context without shelling out.
+//
+// A typical caller constructs a Runner with functional options, runs it, and
+// renders the typed Result:
+//
+//	r := ctxrun.New(ctxrun.WithMaxParallel(4), ctxrun.WithPluginTimeout(30*time.Second))
+//	res, err := r.Run(ctx)
+//	if err != nil {
+//		return err
+//	}
+//	out, err := ctxrun.Format(res, ctxrun.FormatOptions{Format: "json", Indent: 2})
+//
+// See docs/PLUGIN_SPEC.md for the contract plugins must follow.
+package ctxrun
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const sessionEnvKey = "CTX_SESSION"
+const shlvlEnvKey = "CTX_SHLVL"
+const showSourceEnvKey = "CTX_SHOW_SOURCE" // Always implies txtar format
+const deadlineEnvKey = "CTX_DEADLINE_TIMESTAMP"
+
+// DefaultAmbientEnvKeys lists environment variables propagated to plugins if
+// set in the caller's environment.
+var DefaultAmbientEnvKeys = []string{
+	"TRACEPARENT", // OpenTelemetry Trace Context
+	"TRACESTATE",  // OpenTelemetry Trace Context
+	// Add other standard ambient variables here as needed
+}
+
+// Runner discovers and executes ctx-* plugins.
+// The zero value is not usable; construct one with New.
+type Runner struct {
+	cacheDir            string
+	outputTokenBudget   int
+	thinkingTokenBudget int
+	costBudgetCents     int
+	allowedTools        string
+	pluginTimeout       time.Duration // Per-plugin deadline, measured from each plugin's start
+	globalTimeout       time.Duration // Wall-clock cap on the whole run
+	killGrace           time.Duration // Time between SIGTERM and SIGKILL
+	pluginRetries       int
+	retryBackoff        time.Duration
+	retryExitCodes      []int
+	maxParallel         int  // Maximum number of plugins to run in parallel
+	showSource          bool // Request plugin source (always in txtar format)
+	ambientEnvKeys      []string
+	noCache             bool // Disable the host-side result cache
+	refreshCache        bool // Ignore cached results but store fresh ones
+	trust               *TrustFile
+	trustPolicy         TrustPolicy
+	queryCapabilities   bool   // Ask plugins for capability documents via SpecFlag
+	mode                string // Requested mode; plugins not supporting it are skipped
+	strictSchemas       bool   // Reject plugin data that violates its declared schema
+	budgetPolicy        BudgetPolicy
+	tokenizer           Tokenizer
+	priority            map[string]int          // Plugin name to priority; higher runs first and is dropped last
+	only                []string                // Plugin selectors; empty selects all (see Select)
+	skip                []string                // Plugin selectors to exclude
+	pluginConfig        map[string]PluginConfig // Keyed by PluginName
+	pluginDirs          []string                // Searched before CTX_PLUGIN_PATH, PATH and .ctx/plugins
+	duplicatePolicy     DuplicatePolicy         // What to keep when plugins report the same name
+	approver            Approver
+	approvalMu          sync.Mutex // Serializes approval prompts
+	resultHandler       func(Outcome)
+	session             string        // Overrides an inherited CTX_SESSION
+	resultCache         *ResultCache  // In-memory results shared between Runners
+	processLimit        *ProcessLimit // Plugin processes shared between Runners
+	logDir              string        // Directory of per-session plugin stderr logs; empty disables them
+	logKeep             int           // Session logs kept in logDir; older ones are removed
+	embedStderr         int           // Maximum bytes of stderr recorded per plugin in Result.Stderr
+	handlerMu           sync.Mutex    // Serializes resultHandler calls
+	logger              *log.Logger
+}
+
+// Result holds the aggregated output of a single run.
+type Result struct {
+	// SessionID is the CTX_SESSION value passed to every plugin.
+	SessionID string
+	// SessionStart is the time encoded in SessionID, or zero if the
+	// session was inherited with a non-ULID ID.
+	SessionStart time.Time
+	// Plugins maps each plugin's reported name to its output.
+	Plugins map[string]PluginData
+	// Paths maps each name in Plugins to the executable that produced it.
+	Paths map[string]string
+	// Cached lists, sorted, the plugins whose results were served from the
+	// host-side cache without executing them.
+	Cached []string
+	// Skipped lists, sorted, the paths of plugins not run because their
+	// capability document does not support the requested mode.
+	Skipped []string
+	// Errors lists plugins that failed to produce a result, sorted by path.
+	Errors []*PluginError
+	// SchemaViolations maps plugin names to violations of their declared
+	// data schema. Under strict validation such plugins are in Errors instead.
+	SchemaViolations map[string][]string
+	// Duplicates lists plugin names reported by more than one plugin, and
+	// how each conflict was resolved.
+	Duplicates []DuplicateName
+	// Attempts maps plugin names to the number of executions their result
+	// took, for plugins that succeeded only after retrying.
+	Attempts map[string]int
+	// Budget reports token and cost usage against the configured budgets
+	// and what the budget policy cut. It is nil if no budget is set.
+	Budget *BudgetReport
+	// Stderr maps plugin names to what they wrote to stderr, capped as set
+	// by WithStderrInOutput, for plugins that succeeded and wrote any.
+	Stderr map[string]string
+	// LogFile is the session log holding the stderr of every plugin run in
+	// this session, if any was written.
+	LogFile string
+	// Warnings holds non-fatal diagnostics, such as trust mismatches
+	// tolerated under TrustWarn, sorted.
+	Warnings []string
+	// Duration is how long executing the plugins took.
+	Duration time.Duration
+}
+
+// New returns a Runner configured by opts.
+func New(opts ...Option) *Runner {
+	r := &Runner{
+		maxParallel:     1,
+		killGrace:       DefaultKillGrace,
+		retryBackoff:    DefaultRetryBackoff,
+		retryExitCodes:  DefaultRetryExitCodes,
+		ambientEnvKeys:  DefaultAmbientEnvKeys,
+		approver:        DenyAll,
+		budgetPolicy:    BudgetNone,
+		duplicatePolicy: DuplicateFirst,
+		tokenizer:       DefaultTokenizer,
+		logger:          log.New(io.Discard, "", 0),
+		logKeep:         DefaultLogRetention,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	if r.maxParallel < 1 {
+		r.maxParallel = 1
+	}
+	return r
+}
+
+// logf writes a diagnostic message to the configured logger.
+func (r *Runner) logf(format string, args ...any) {
+	r.logger.Printf(format, args...)
+}
+
+// Run discovers plugins, executes them and returns the aggregated result.
+func (r *Runner) Run(ctx context.Context) (*Result, error) {
+	sessionID, sessionStart := r.sessionID()
+	return r.run(ctx, sessionID, sessionStart)
+}
+
+// run is Run within the given session.
+func (r *Runner) run(ctx context.Context, sessionID string, sessionStart time.Time) (*Result, error) {
+	if r.resultHandler != nil {
+		if err := r.checkStreamable(); err != nil {
+			return nil, err
+		}
+	}
+	r.logf("Discovering plugins...")
+	discoveredPlugins, warnings, err := r.Discover()
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover plugins: %w", err)
+	}
+	if len(discoveredPlugins) == 0 {
+		r.logf("No ctx-* plugins found.")
+	} else {
+		r.logf("Found %d potential plugin(s).", len(discoveredPlugins))
+	}
+	selected, selectWarnings, err := r.Select(ctx, discoveredPlugins)
+	if err != nil {
+		return nil, err
+	}
+	warnings = append(warnings, selectWarnings...)
+	res, err := r.execute(ctx, selected, sessionID, sessionStart)
+	if res != nil && len(warnings) > 0 {
+		res.Warnings = append(res.Warnings, warnings...)
+		sort.Strings(res.Warnings)
+	}
+	return res, err
+}
+
+// Execute runs the given plugins and returns the aggregated result.
+func (r *Runner) Execute(ctx context.Context, pluginPaths []string) (*Result, error) {
+	sessionID, sessionStart := r.sessionID()
+	return r.execute(ctx, pluginPaths, sessionID, sessionStart)
+}
+
+// execute is Execute within the given session.
+func (r *Runner) execute(ctx context.Context, pluginPaths []string, sessionID string, sessionStart time.Time) (*Result, error) {
+	start := time.Now()
+	res := &Result{SessionID: sessionID, SessionStart: sessionStart, Plugins: map[string]PluginData{}, Paths: map[string]string{}}
+	if r.resultHandler != nil {
+		if err := r.checkStreamable(); err != nil {
+			return nil, err
+		}
+	}
+	if len(pluginPaths) == 0 {
+		r.logf("No plugins found to execute.")
+		return res, nil
+	}
+
+	r.logf("Executing %d potential plugin(s)...", len(pluginPaths))
+	r.logf("Session ID: %s", sessionID)
+	pluginEnv := r.pluginEnv(sessionID)
+
+	if r.globalTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.globalTimeout)
+		defer cancel()
+	}
+
+	r.executePlugins(ctx, pluginPaths, pluginEnv, res)
+	res.Duration = time.Since(start)
+	if r.logDir != "" {
+		if logFile := SessionLogPath(r.logDir, sessionID); isFile(logFile) {
+			res.LogFile = logFile
+			r.pruneLogs(logFile)
+		}
+	}
+	r.logf("Finished execution. Aggregated results from %d plugin(s), %d failed.", len(res.Plugins), len(res.Errors))
+	if err := r.applyBudget(res); err != nil {
+		return res, err
+	}
+	return res, nil
+}
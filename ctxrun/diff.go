@@ -0,0 +1,168 @@
+package ctxrun
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// ChangeKind classifies a Change.
+type ChangeKind string
+
+const (
+	ChangeAdded    ChangeKind = "added"    // A plugin produced a result it did not before
+	ChangeModified ChangeKind = "modified" // A plugin's version or data changed
+	ChangeRemoved  ChangeKind = "removed"  // A plugin no longer produces a result
+	ChangeFailed   ChangeKind = "failed"   // A plugin failed, or now fails differently
+)
+
+// Change is a difference between two results for one plugin.
+type Change struct {
+	Kind ChangeKind
+	// Name is the plugin's key in Result.Plugins. It is empty for
+	// ChangeFailed; see Err.Path.
+	Name string
+	// Plugin is the current result, for ChangeAdded and ChangeModified.
+	Plugin PluginData
+	// Patch turns the previous data into the current, for ChangeModified.
+	// It is empty if only the version changed.
+	Patch []PatchOp
+	// Err is the current failure, for ChangeFailed.
+	Err *PluginError
+}
+
+// PatchOp is a JSON Patch (RFC 6902) operation. Diff produces only add,
+// remove and replace operations.
+type PatchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"` // JSON Pointer (RFC 6901)
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Diff reports how cur differs from prev: plugins added, modified and
+// removed in name order, then new or different failures in path order.
+// A nil prev is empty, so every plugin is added and every failure new.
+func Diff(prev, cur *Result) []Change {
+	if prev == nil {
+		prev = &Result{}
+	}
+	var changes []Change
+	for _, name := range pluginOrder(cur.Plugins, nil) {
+		p := cur.Plugins[name]
+		old, ok := prev.Plugins[name]
+		if !ok {
+			changes = append(changes, Change{Kind: ChangeAdded, Name: name, Plugin: p})
+			continue
+		}
+		patch := diffJSON(old.Data, p.Data)
+		if len(patch) > 0 || old.Version != p.Version {
+			changes = append(changes, Change{Kind: ChangeModified, Name: name, Plugin: p, Patch: patch})
+		}
+	}
+	for _, name := range pluginOrder(prev.Plugins, nil) {
+		if _, ok := cur.Plugins[name]; !ok {
+			changes = append(changes, Change{Kind: ChangeRemoved, Name: name})
+		}
+	}
+	prevErrs := make(map[string]*PluginError, len(prev.Errors))
+	for _, e := range prev.Errors {
+		prevErrs[e.Path] = e
+	}
+	for _, e := range cur.Errors {
+		if old := prevErrs[e.Path]; old == nil || old.Kind != e.Kind || old.ExitCode != e.ExitCode || old.Message != e.Message || old.Stderr != e.Stderr {
+			changes = append(changes, Change{Kind: ChangeFailed, Err: e})
+		}
+	}
+	return changes
+}
+
+// diffJSON returns the operations turning JSON document a into b. Documents
+// that fail to decode are compared as raw bytes.
+func diffJSON(a, b json.RawMessage) []PatchOp {
+	av, aerr := decodeJSON(a)
+	bv, berr := decodeJSON(b)
+	if aerr != nil || berr != nil {
+		if bytes.Equal(a, b) {
+			return nil
+		}
+		return []PatchOp{{Op: "replace", Path: "", Value: b}}
+	}
+	return diffValue(nil, "", av, bv)
+}
+
+func decodeJSON(data json.RawMessage) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	err := dec.Decode(&v)
+	return v, err
+}
+
+// diffValue appends to ops the operations turning a into b at pointer path.
+// Arrays are compared by index.
+func diffValue(ops []PatchOp, path string, a, b any) []PatchOp {
+	switch av := a.(type) {
+	case map[string]any:
+		bv, ok := b.(map[string]any)
+		if !ok {
+			break
+		}
+		for _, k := range sortedKeys(av) {
+			if _, ok := bv[k]; !ok {
+				ops = append(ops, PatchOp{Op: "remove", Path: path + "/" + escapePointer(k)})
+			}
+		}
+		for _, k := range sortedKeys(bv) {
+			if old, ok := av[k]; ok {
+				ops = diffValue(ops, path+"/"+escapePointer(k), old, bv[k])
+			} else {
+				ops = append(ops, PatchOp{Op: "add", Path: path + "/" + escapePointer(k), Value: mustMarshal(bv[k])})
+			}
+		}
+		return ops
+	case []any:
+		bv, ok := b.([]any)
+		if !ok {
+			break
+		}
+		n := len(av)
+		if len(bv) < n {
+			n = len(bv)
+		}
+		for i := 0; i < n; i++ {
+			ops = diffValue(ops, path+"/"+strconv.Itoa(i), av[i], bv[i])
+		}
+		for i := len(av) - 1; i >= n; i-- { // From the end, so indexes stay valid
+			ops = append(ops, PatchOp{Op: "remove", Path: path + "/" + strconv.Itoa(i)})
+		}
+		for i := n; i < len(bv); i++ {
+			ops = append(ops, PatchOp{Op: "add", Path: path + "/" + strconv.Itoa(i), Value: mustMarshal(bv[i])})
+		}
+		return ops
+	default:
+		if reflect.DeepEqual(a, b) {
+			return ops
+		}
+	}
+	return append(ops, PatchOp{Op: "replace", Path: path, Value: mustMarshal(b)})
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// mustMarshal marshals a value decoded by decodeJSON, which cannot fail.
+func mustMarshal(v any) json.RawMessage {
+	b, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
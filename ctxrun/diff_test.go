@@ -0,0 +1,184 @@
+package ctxrun
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestDiffJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want string // The patch, as JSON
+	}{
+		{"equal", `{"a":[1,{"b":null}]}`, `{"a":[1,{"b":null}]}`, `null`},
+		{"equal with other formatting", `{"a": 1, "b": 2}`, `{"b":2,"a":1}`, `null`},
+		{"scalar root", `1`, `2`, `[{"op":"replace","path":"","value":2}]`},
+		{"type change at root", `{"a":1}`, `[1]`, `[{"op":"replace","path":"","value":[1]}]`},
+		{"added key", `{"a":1}`, `{"a":1,"b":{"c":true}}`, `[{"op":"add","path":"/b","value":{"c":true}}]`},
+		{"removed key", `{"a":1,"b":2}`, `{"a":1}`, `[{"op":"remove","path":"/b"}]`},
+		{"removals before additions", `{"x":1,"b":1}`, `{"a":1,"b":1}`, `[{"op":"remove","path":"/x"},{"op":"add","path":"/a","value":1}]`},
+		{"nested object", `{"a":{"b":{"c":1,"d":2}}}`, `{"a":{"b":{"c":3,"d":2}}}`, `[{"op":"replace","path":"/a/b/c","value":3}]`},
+		{"number precision", `{"n":12345678901234567890}`, `{"n":12345678901234567891}`, `[{"op":"replace","path":"/n","value":12345678901234567891}]`},
+		{"null to value", `{"a":null}`, `{"a":"x"}`, `[{"op":"replace","path":"/a","value":"x"}]`},
+		{"array element", `[1,2,3]`, `[1,5,3]`, `[{"op":"replace","path":"/1","value":5}]`},
+		{"array grows", `[1]`, `[1,2,3]`, `[{"op":"add","path":"/1","value":2},{"op":"add","path":"/2","value":3}]`},
+		{"array shrinks from the end", `[1,2,3]`, `[1]`, `[{"op":"remove","path":"/2"},{"op":"remove","path":"/1"}]`},
+		{"array in object", `{"a":[{"b":1}]}`, `{"a":[{"b":2},{"b":3}]}`, `[{"op":"replace","path":"/a/0/b","value":2},{"op":"add","path":"/a/1","value":{"b":3}}]`},
+		{"slash in key", `{"a/b":1}`, `{"a/b":2}`, `[{"op":"replace","path":"/a~1b","value":2}]`},
+		{"tilde in key", `{"~":{"x":1}}`, `{"~":{}}`, `[{"op":"remove","path":"/~0/x"}]`},
+		{"tilde and slash", `{}`, `{"~1/~0":0}`, `[{"op":"add","path":"/~01~1~00","value":0}]`},
+		{"empty key", `{"":1}`, `{"":2}`, `[{"op":"replace","path":"/","value":2}]`},
+		{"invalid JSON unchanged", `{`, `{`, `null`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ops := diffJSON(json.RawMessage(tt.a), json.RawMessage(tt.b))
+			var got string
+			if ops == nil {
+				got = "null"
+			} else {
+				parts := make([]string, len(ops))
+				for i, op := range ops {
+					parts[i] = fmt.Sprintf(`{"op":%q,"path":%q`, op.Op, op.Path)
+					if op.Value != nil {
+						parts[i] += `,"value":` + string(op.Value)
+					}
+					parts[i] += "}"
+				}
+				got = "[" + strings.Join(parts, ",") + "]"
+			}
+			if got != tt.want {
+				t.Fatalf("diffJSON =\n%s\nwant\n%s", got, tt.want)
+			}
+			a, aerr := decodeJSON(json.RawMessage(tt.a))
+			b, berr := decodeJSON(json.RawMessage(tt.b))
+			if aerr != nil || berr != nil {
+				return
+			}
+			patched, err := applyPatch(a, ops)
+			if err != nil {
+				t.Fatalf("applying the patch: %v", err)
+			}
+			if !reflect.DeepEqual(patched, b) {
+				t.Errorf("applying the patch gives %v, want %v", patched, b)
+			}
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	data := func(s string) PluginData { return PluginData{Version: "1", Data: json.RawMessage(s)} }
+	prev := &Result{
+		Plugins: map[string]PluginData{"same": data(`1`), "changed": data(`{"a":1}`), "bumped": data(`1`), "gone": data(`1`)},
+		Errors:  []*PluginError{{Path: "/bin/ctx-a", Kind: ErrorKindExec, ExitCode: 1}, {Path: "/bin/ctx-b", Kind: ErrorKindExec, ExitCode: 1}},
+	}
+	bumped := data(`1`)
+	bumped.Version = "2"
+	cur := &Result{
+		Plugins: map[string]PluginData{"same": data(`1`), "changed": data(`{"a":2}`), "bumped": bumped, "new": data(`3`)},
+		Errors:  []*PluginError{{Path: "/bin/ctx-a", Kind: ErrorKindExec, ExitCode: 1}, {Path: "/bin/ctx-b", Kind: ErrorKindExec, ExitCode: 2}, {Path: "/bin/ctx-c", Kind: ErrorKindTimeout}},
+	}
+	var got []string
+	for _, c := range Diff(prev, cur) {
+		s := string(c.Kind) + " " + c.Name
+		if c.Err != nil {
+			s += c.Err.Path
+		}
+		if c.Patch != nil {
+			s += fmt.Sprintf(" %d ops", len(c.Patch))
+		}
+		got = append(got, s)
+	}
+	want := []string{"modified bumped", "modified changed 1 ops", "added new", "removed gone", "failed /bin/ctx-b", "failed /bin/ctx-c"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff =\n%q\nwant\n%q", got, want)
+	}
+
+	if changes := Diff(nil, &Result{Plugins: map[string]PluginData{"a": data(`1`)}}); len(changes) != 1 || changes[0].Kind != ChangeAdded {
+		t.Errorf("Diff from nil = %+v, want one addition", changes)
+	}
+}
+
+// applyPatch applies the add, remove and replace operations of a JSON Patch
+// to a value decoded by decodeJSON.
+func applyPatch(doc any, ops []PatchOp) (any, error) {
+	for _, op := range ops {
+		var value any
+		if op.Op != "remove" {
+			v, err := decodeJSON(op.Value)
+			if err != nil {
+				return nil, err
+			}
+			value = v
+		}
+		if op.Path == "" {
+			if op.Op != "replace" {
+				return nil, fmt.Errorf("%s at the root", op.Op)
+			}
+			doc = value
+			continue
+		}
+		tokens := strings.Split(op.Path[1:], "/")
+		for i, tok := range tokens {
+			tokens[i] = strings.ReplaceAll(strings.ReplaceAll(tok, "~1", "/"), "~0", "~")
+		}
+		var err error
+		doc, err = patchAt(doc, tokens, op.Op, value)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %w", op.Op, op.Path, err)
+		}
+	}
+	return doc, nil
+}
+
+// patchAt applies one operation at the location tokens address within v
+// and returns the updated v.
+func patchAt(v any, tokens []string, op string, value any) (any, error) {
+	tok, last := tokens[0], len(tokens) == 1
+	switch t := v.(type) {
+	case map[string]any:
+		_, exists := t[tok]
+		switch {
+		case !last:
+			if !exists {
+				return nil, fmt.Errorf("no key %q", tok)
+			}
+			child, err := patchAt(t[tok], tokens[1:], op, value)
+			t[tok] = child
+			return t, err
+		case op == "remove" || op == "replace":
+			if !exists {
+				return nil, fmt.Errorf("no key %q", tok)
+			}
+		}
+		if op == "remove" {
+			delete(t, tok)
+		} else {
+			t[tok] = value
+		}
+		return t, nil
+	case []any:
+		i, err := strconv.Atoi(tok)
+		if err != nil || i < 0 || i > len(t) || (i == len(t) && (op != "add" || !last)) {
+			return nil, fmt.Errorf("bad index %q", tok)
+		}
+		switch {
+		case !last:
+			child, err := patchAt(t[i], tokens[1:], op, value)
+			t[i] = child
+			return t, err
+		case op == "add":
+			return append(t[:i], append([]any{value}, t[i:]...)...), nil
+		case op == "remove":
+			return append(t[:i], t[i+1:]...), nil
+		}
+		t[i] = value
+		return t, nil
+	}
+	return nil, fmt.Errorf("cannot index %T", v)
+}
@@ -0,0 +1,167 @@
+package ctxrun
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// PluginPathEnvKey names a list of extra plugin directories, separated like
+// PATH, searched after any WithPluginDirs directories.
+const PluginPathEnvKey = "CTX_PLUGIN_PATH"
+
+// ProjectPluginDir is the project-local plugin directory, looked up in the
+// working directory and its parents up to the project root (see
+// ProjectPluginDirs).
+const ProjectPluginDir = ".ctx/plugins"
+
+// Shadowed records a plugin executable hidden by one of the same name in a
+// directory of higher precedence.
+type Shadowed struct {
+	Path string // The executable not run
+	By   string // The executable run instead
+}
+
+func (s Shadowed) String() string {
+	return fmt.Sprintf("%s is shadowed by %s", s.Path, s.By)
+}
+
+// FindPlugins searches PATH for executables starting with "ctx-".
+// Returns a list of full paths to potential plugins.
+func FindPlugins() ([]string, error) {
+	pathEnv := os.Getenv("PATH")
+	if pathEnv == "" {
+		return nil, errors.New("PATH environment variable is not set")
+	}
+	plugins, _ := FindPluginsIn(filepath.SplitList(pathEnv))
+	return plugins, nil
+}
+
+// FindPluginsIn searches dirs, in order, for executables starting with
+// "ctx-". Like a shell resolving commands, the first executable with a given
+// name wins; later ones are returned as shadowed.
+func FindPluginsIn(dirs []string) ([]string, []Shadowed) {
+	var plugins []string
+	var shadowed []Shadowed
+	byName := make(map[string]string)
+
+	checked := make(map[string]struct{})
+	selfPath, _ := os.Executable() // Get our own path to ensure we don't create infinite loop
+
+	for _, path := range dirs {
+		if path == "" {
+			continue
+		}
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			continue
+		}
+		if _, ok := checked[absPath]; ok {
+			continue
+		}
+		checked[absPath] = struct{}{}
+
+		files, err := os.ReadDir(absPath)
+		if err != nil {
+			continue
+		}
+
+		for _, file := range files {
+			if file.IsDir() {
+				continue
+			}
+
+			fileName := file.Name()
+			if !strings.HasPrefix(fileName, "ctx-") {
+				continue
+			}
+
+			pluginPath := filepath.Join(absPath, fileName)
+
+			// Skip ourselves to avoid infinite recursion
+			if pluginPath == selfPath {
+				continue
+			}
+
+			info, err := file.Info()
+			if err != nil || !(info.Mode()&0111 != 0 || runtime.GOOS == "windows") {
+				continue
+			}
+
+			if first, ok := byName[fileName]; ok {
+				shadowed = append(shadowed, Shadowed{Path: pluginPath, By: first})
+				continue
+			}
+			byName[fileName] = pluginPath
+			plugins = append(plugins, pluginPath)
+		}
+	}
+	return plugins, shadowed
+}
+
+// PluginDirs returns the directories searched for plugins, highest
+// precedence first: WithPluginDirs directories, CTX_PLUGIN_PATH, PATH, then
+// the project's .ctx/plugins directories. Project plugins come last so that
+// a checked-out repository cannot shadow the user's installed plugins.
+func (r *Runner) PluginDirs() []string {
+	dirs := append([]string(nil), r.pluginDirs...)
+	dirs = append(dirs, filepath.SplitList(os.Getenv(PluginPathEnvKey))...)
+	dirs = append(dirs, filepath.SplitList(os.Getenv("PATH"))...)
+	if wd, err := os.Getwd(); err == nil {
+		dirs = append(dirs, ProjectPluginDirs(wd)...)
+	}
+	return dirs
+}
+
+// ProjectPluginDirs returns the existing .ctx/plugins directories in dir and
+// its parents, nearest first. The search stops at the project root, the
+// nearest directory containing .git, or at the user's home directory; if
+// dir is under neither, only dir itself is searched, so that a directory
+// above the project cannot contribute plugins.
+func ProjectPluginDirs(dir string) []string {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil
+	}
+	home, _ := os.UserHomeDir()
+	var candidates []string
+	for d := dir; ; {
+		candidates = append(candidates, d)
+		if _, err := os.Lstat(filepath.Join(d, ".git")); err == nil || d == home {
+			break
+		}
+		parent := filepath.Dir(d)
+		if parent == d {
+			candidates = candidates[:1] // No boundary found
+			break
+		}
+		d = parent
+	}
+	var dirs []string
+	for _, d := range candidates {
+		p := filepath.Join(d, ProjectPluginDir)
+		if info, err := os.Stat(p); err == nil && info.IsDir() {
+			dirs = append(dirs, p)
+		}
+	}
+	return dirs
+}
+
+// Discover returns the full paths of the plugins this Runner would execute,
+// searching PluginDirs, along with warnings for shadowed plugins.
+func (r *Runner) Discover() ([]string, []string, error) {
+	dirs := r.PluginDirs()
+	if len(dirs) == 0 {
+		return nil, nil, errors.New("no plugin directories: PATH environment variable is not set")
+	}
+	plugins, shadowed := FindPluginsIn(dirs)
+	var warnings []string
+	for _, s := range shadowed {
+		r.logf("Warning: Plugin %s.", s)
+		warnings = append(warnings, "plugin "+s.String())
+	}
+	return plugins, warnings, nil
+}
@@ -0,0 +1,98 @@
+package ctxrun
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// DuplicatePolicy controls what happens when several plugins report the
+// same name.
+type DuplicatePolicy string
+
+const (
+	DuplicateFirst     DuplicatePolicy = "first"     // Keep the first plugin in discovery order
+	DuplicateNamespace DuplicatePolicy = "namespace" // Keep all, keyed as name@path
+	DuplicateError     DuplicatePolicy = "error"     // Keep none; each is reported as an error
+)
+
+// ParseDuplicatePolicy parses a DuplicatePolicy name.
+func ParseDuplicatePolicy(s string) (DuplicatePolicy, error) {
+	switch p := DuplicatePolicy(strings.ToLower(s)); p {
+	case DuplicateFirst, DuplicateNamespace, DuplicateError:
+		return p, nil
+	}
+	return "", fmt.Errorf("invalid duplicate policy %q (want first, namespace or error)", s)
+}
+
+// DuplicateName records plugins that reported the same name and how the
+// conflict was resolved.
+type DuplicateName struct {
+	Name   string          `json:"name"`
+	Paths  []string        `json:"paths"` // In discovery order
+	Policy DuplicatePolicy `json:"policy"`
+	Kept   []string        `json:"kept,omitempty"` // Result keys of the plugins kept
+}
+
+// resolveDuplicates assigns each successful outcome the key it is stored
+// under in Result.Plugins, applying the duplicate policy to names reported
+// by more than one plugin. An empty key means the result is discarded.
+// Conflicts are recorded in res.Duplicates, in discovery order.
+func (r *Runner) resolveDuplicates(outcomes []pluginOutcome, res *Result) []string {
+	byName := make(map[string][]int)
+	var names []string
+	for i, out := range outcomes {
+		if out.skipped || out.perr != nil {
+			continue
+		}
+		if _, ok := byName[out.data.Name]; !ok {
+			names = append(names, out.data.Name)
+		}
+		byName[out.data.Name] = append(byName[out.data.Name], i)
+	}
+
+	keys := make([]string, len(outcomes))
+	for _, name := range names {
+		idx := byName[name]
+		if len(idx) == 1 {
+			keys[idx[0]] = name
+			continue
+		}
+		dup := DuplicateName{Name: name, Policy: r.duplicatePolicy}
+		for _, i := range idx {
+			dup.Paths = append(dup.Paths, outcomes[i].path)
+		}
+		switch r.duplicatePolicy {
+		case DuplicateNamespace:
+			for _, i := range idx {
+				keys[i] = name + "@" + outcomes[i].path
+				dup.Kept = append(dup.Kept, keys[i])
+			}
+		case DuplicateError:
+			for _, i := range idx {
+				outcomes[i].perr = &PluginError{
+					Path:    outcomes[i].path,
+					Kind:    ErrorKindDuplicate,
+					Message: fmt.Sprintf("plugin name %q is also reported by %s", name, otherPaths(dup.Paths, outcomes[i].path)),
+				}
+			}
+		default:
+			keys[idx[0]] = name
+			dup.Kept = []string{name}
+		}
+		r.logf("Warning: Plugin name '%s' reported by %d plugins (%s); policy '%s'.", name, len(idx), strings.Join(dup.Paths, ", "), dup.Policy)
+		res.Duplicates = append(res.Duplicates, dup)
+	}
+	return keys
+}
+
+// otherPaths lists the paths other than self, by base name.
+func otherPaths(paths []string, self string) string {
+	var others []string
+	for _, p := range paths {
+		if p != self {
+			others = append(others, filepath.Base(p))
+		}
+	}
+	return strings.Join(others, ", ")
+}
@@ -0,0 +1,74 @@
+package ctxrun
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestDuplicatePolicies(t *testing.T) {
+	dir := t.TempDir()
+	plugin := func(file, name, sleep string, data int) string {
+		return writePlugin(t, dir, file, fmt.Sprintf("sleep %s\necho '{\"name\":\"%s\",\"version\":\"1\",\"data\":%d}'\n", sleep, name, data))
+	}
+	// Discovery order is slow, fast, other: the later duplicate finishes first.
+	slow := plugin("ctx-slow", "dup", "0.2", 1)
+	fast := plugin("ctx-fast", "dup", "0", 2)
+	other := plugin("ctx-other", "other", "0", 3)
+	paths := []string{slow, fast, other}
+
+	tests := []struct {
+		policy DuplicatePolicy
+		want   map[string]string // Result key to data
+		kept   []string
+		errors []string // Paths of failed plugins
+	}{
+		{DuplicateFirst, map[string]string{"dup": "1", "other": "3"}, []string{"dup"}, nil},
+		{DuplicateNamespace, map[string]string{"dup@" + slow: "1", "dup@" + fast: "2", "other": "3"}, []string{"dup@" + slow, "dup@" + fast}, nil},
+		{DuplicateError, map[string]string{"other": "3"}, nil, []string{fast, slow}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			r := New(WithDuplicatePolicy(tt.policy), WithMaxParallel(3), WithNoCache(true))
+			res, err := r.Execute(context.Background(), paths)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make(map[string]string)
+			for key, p := range res.Plugins {
+				got[key] = string(p.Data)
+				if p.Name != key && p.Name+"@"+res.Paths[key] != key {
+					t.Errorf("plugin stored as %q reports name %q", key, p.Name)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("plugins = %v, want %v", got, tt.want)
+			}
+			wantDup := []DuplicateName{{Name: "dup", Paths: []string{slow, fast}, Policy: tt.policy, Kept: tt.kept}}
+			if !reflect.DeepEqual(res.Duplicates, wantDup) {
+				t.Errorf("duplicates = %+v, want %+v", res.Duplicates, wantDup)
+			}
+			var errPaths []string
+			for _, e := range res.Errors {
+				if e.Kind != ErrorKindDuplicate {
+					t.Errorf("error %v, want kind %s", e, ErrorKindDuplicate)
+				}
+				errPaths = append(errPaths, e.Path)
+			}
+			sort.Strings(tt.errors)
+			if !reflect.DeepEqual(errPaths, tt.errors) {
+				t.Errorf("errors for %v, want %v", errPaths, tt.errors)
+			}
+			for key, path := range res.Paths {
+				if _, ok := res.Plugins[key]; !ok || (key == "other") != (path == other) {
+					t.Errorf("Paths[%q] = %s", key, path)
+				}
+			}
+			if len(res.Paths) != len(res.Plugins) {
+				t.Errorf("Paths %v does not match Plugins", res.Paths)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package ctxrun
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// getCurrentShlvl reads the current CTX_SHLVL or SHLVL, defaulting to 0.
+func getCurrentShlvl() int {
+	levelStr := os.Getenv(shlvlEnvKey)
+	if levelStr == "" {
+		levelStr = os.Getenv("SHLVL")
+	}
+	level, err := strconv.Atoi(levelStr)
+	if err != nil || level < 0 {
+		return 0
+	}
+	return level
+}
+
+// pluginEnv creates the environment slice for plugin execution.
+func (r *Runner) pluginEnv(sessionID string) []string {
+	currentEnv := os.Environ()
+	finalEnv := make([]string, 0, len(currentEnv)+2+len(r.ambientEnvKeys)+8)
+
+	varsToSet := make(map[string]string)
+	managedKeys := make(map[string]struct{})
+
+	// 1. Set CTX_SESSION
+	varsToSet[sessionEnvKey] = sessionID
+	managedKeys[sessionEnvKey] = struct{}{}
+
+	// 2. Set CTX_SHLVL
+	currentLevel := getCurrentShlvl()
+	varsToSet[shlvlEnvKey] = strconv.Itoa(currentLevel + 1)
+	managedKeys[shlvlEnvKey] = struct{}{}
+	managedKeys["SHLVL"] = struct{}{} // Don't inherit standard SHLVL
+
+	// 3. Handle ambient context propagation (Otel etc)
+	for _, key := range r.ambientEnvKeys {
+		if value, exists := os.LookupEnv(key); exists {
+			varsToSet[key] = value
+		}
+		managedKeys[key] = struct{}{}
+	}
+
+	// 4. Handle configuration propagation from options
+	if cacheDirToSet := r.resolveCacheDir(); cacheDirToSet != "" {
+		varsToSet["CTX_CACHE_DIR"] = cacheDirToSet
+		managedKeys["CTX_CACHE_DIR"] = struct{}{}
+	}
+
+	if r.outputTokenBudget > 0 {
+		varsToSet["CTX_OUTPUT_TOKEN_BUDGET"] = strconv.Itoa(r.outputTokenBudget)
+		managedKeys["CTX_OUTPUT_TOKEN_BUDGET"] = struct{}{}
+	}
+	if r.thinkingTokenBudget > 0 {
+		varsToSet["CTX_THINKING_TOKEN_BUDGET"] = strconv.Itoa(r.thinkingTokenBudget)
+		managedKeys["CTX_THINKING_TOKEN_BUDGET"] = struct{}{}
+	}
+	if r.costBudgetCents > 0 {
+		varsToSet["CTX_COST_BUDGET_CENTS"] = strconv.Itoa(r.costBudgetCents)
+		managedKeys["CTX_COST_BUDGET_CENTS"] = struct{}{}
+	}
+	if r.allowedTools != "" {
+		varsToSet["CTX_ALLOWED_TOOLS"] = r.allowedTools
+		managedKeys["CTX_ALLOWED_TOOLS"] = struct{}{}
+	}
+	if r.pluginTimeout > 0 {
+		timeoutSec := int(r.pluginTimeout.Seconds())
+		if timeoutSec > 0 {
+			varsToSet["CTX_TIMEOUT_SECONDS"] = strconv.Itoa(timeoutSec)
+			managedKeys["CTX_TIMEOUT_SECONDS"] = struct{}{}
+		}
+	}
+	// CTX_DEADLINE_TIMESTAMP is set per plugin when it starts (see execPlugin)
+	managedKeys[deadlineEnvKey] = struct{}{}
+	if r.pluginRetries > 0 {
+		varsToSet["CTX_RETRY_MAX"] = strconv.Itoa(r.pluginRetries)
+		managedKeys["CTX_RETRY_MAX"] = struct{}{}
+	}
+
+	// Set source flag if enabled
+	if r.showSource {
+		// When showing source, it's always in txtar format
+		varsToSet[showSourceEnvKey] = "true"
+		managedKeys[showSourceEnvKey] = struct{}{}
+	}
+
+	// Never inherit approval; it is only set when re-running an approved plugin
+	managedKeys[approvedEnvKey] = struct{}{}
+
+	// Filter currentEnv, keeping only non-managed vars
+	for _, envVar := range currentEnv {
+		parts := strings.SplitN(envVar, "=", 2)
+		if len(parts) > 0 {
+			if _, manageThis := managedKeys[parts[0]]; !manageThis {
+				finalEnv = append(finalEnv, envVar)
+			}
+		}
+	}
+	// Add the explicitly managed vars
+	for key, value := range varsToSet {
+		finalEnv = append(finalEnv, fmt.Sprintf("%s=%s", key, value))
+	}
+	return finalEnv
+}
+
+// resolveCacheDir returns the absolute cache directory handed to plugins,
+// falling back to $XDG_CACHE_HOME/ctx. It returns "" if none can be determined.
+func (r *Runner) resolveCacheDir() string {
+	if r.cacheDir != "" {
+		absCacheDir, err := filepath.Abs(r.cacheDir)
+		if err != nil {
+			r.logf("Warning: Could not resolve absolute path for cache dir '%s'. CTX_CACHE_DIR will not be set.", r.cacheDir)
+			return ""
+		}
+		return absCacheDir
+	}
+	xdgCacheHome := os.Getenv("XDG_CACHE_HOME")
+	if xdgCacheHome == "" {
+		homeDir, err := os.UserHomeDir()
+		if err == nil {
+			xdgCacheHome = filepath.Join(homeDir, ".cache")
+		}
+	}
+	if xdgCacheHome == "" {
+		return ""
+	}
+	return filepath.Join(xdgCacheHome, "ctx")
+}
@@ -0,0 +1,46 @@
+package ctxrun
+
+import (
+	"fmt"
+	"time"
+)
+
+// maxStderrLen caps the amount of plugin stderr kept in a PluginError.
+const maxStderrLen = 1024
+
+// ErrorKind classifies why a plugin did not produce a result.
+type ErrorKind string
+
+const (
+	ErrorKindExec       ErrorKind = "exec"       // Plugin failed to start or exited non-zero
+	ErrorKindTimeout    ErrorKind = "timeout"    // Plugin was killed after its deadline passed
+	ErrorKindParse      ErrorKind = "parse"      // Standard output was not a valid JSON object
+	ErrorKindValidation ErrorKind = "validation" // Output lacked a required field
+	ErrorKindDenied     ErrorKind = "denied"     // Plugin required approval that was not granted
+	ErrorKindUntrusted  ErrorKind = "untrusted"  // Plugin failed trust verification under TrustEnforce
+	ErrorKindSchema     ErrorKind = "schema"     // Data violated its declared schema under strict validation
+	ErrorKindDuplicate  ErrorKind = "duplicate"  // Another plugin reported the same name under DuplicateError
+)
+
+// PluginError records a single plugin failure.
+type PluginError struct {
+	Path     string        `json:"path"`
+	Kind     ErrorKind     `json:"kind"`
+	ExitCode int           `json:"exit_code"` // -1 if the process did not exit normally
+	Message  string        `json:"message"`
+	Stderr   string        `json:"stderr,omitempty"`   // Truncated to maxStderrLen bytes
+	Attempts int           `json:"attempts,omitempty"` // Executions made, including retries
+	Duration time.Duration `json:"-"`
+}
+
+func (e *PluginError) Error() string {
+	return fmt.Sprintf("plugin %s: %s: %s", e.Path, e.Kind, e.Message)
+}
+
+// truncate shortens s to at most n bytes, marking the cut with "...".
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
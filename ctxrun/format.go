@@ -0,0 +1,446 @@
+package ctxrun
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	goyaml "sigs.k8s.io/yaml/goyaml.v2"
+)
+
+// FormatOptions controls how a Result is rendered by Format.
+type FormatOptions struct {
+	Format  string // Output format: yaml (default), json, xml, markdown, prompt, txtar or ndjson
+	Indent  int    // Number of spaces for JSON/XML indentation, also used for data in markdown and prompt
+	Summary bool   // Compact JSON/XML without indentation (overrides Indent)
+	XMLData string // XMLDataStructured (default) or XMLDataJSON
+
+	// Priority orders plugins from highest to lowest value, ties broken by
+	// name. If nil, plugins are ordered by name. Metadata under MetaKey,
+	// when present, always comes first.
+	Priority map[string]int
+}
+
+// MetaKey is the reserved top-level key under which JSON and YAML output
+// carry ctx's own metadata, such as the session and plugin errors. The key
+// is omitted when there is nothing to report beyond the session, so the
+// output of a clean run holds only plugin data.
+const MetaKey = "_ctx"
+
+// outputMeta is the metadata block emitted under MetaKey.
+type outputMeta struct {
+	SessionID    string                `json:"session_id"`
+	SessionStart string                `json:"session_start,omitempty"` // RFC 3339, derived from the ULID
+	LogFile      string                `json:"log_file,omitempty"`      // Session log of plugin stderr
+	Plugins      map[string]pluginMeta `json:"plugins,omitempty"`
+	Usage        *Usage                `json:"usage,omitempty"` // Totals of reported metrics
+	Budget       *BudgetReport         `json:"budget,omitempty"`
+	Cached       []string              `json:"cached,omitempty"`  // Plugins served from the host-side cache
+	Skipped      []string              `json:"skipped,omitempty"` // Plugins not supporting the requested mode
+	Duplicates   []DuplicateName       `json:"duplicates,omitempty"`
+	Errors       []errorRecord         `json:"errors,omitempty"`
+	Warnings     []string              `json:"warnings,omitempty"`
+}
+
+// pluginMeta is the per-plugin metadata block in outputMeta.
+type pluginMeta struct {
+	Version          string   `json:"version"`
+	Attempts         int      `json:"attempts,omitempty"` // Set if the plugin was retried
+	Metrics          *Metrics `json:"metrics,omitempty"`
+	SchemaViolations []string `json:"schema_violations,omitempty"`
+	Stderr           string   `json:"stderr,omitempty"` // Set with WithStderrInOutput
+}
+
+// errorRecord is a PluginError as rendered in JSON and YAML output.
+type errorRecord struct {
+	*PluginError
+	DurationMS int64 `json:"duration_ms"`
+}
+
+// XMLResults is the XML structure for aggregated output.
+type XMLResults struct {
+	XMLName      xml.Name       `xml:"ctx_results"`
+	SessionID    string         `xml:"session_id,attr"`
+	SessionStart string         `xml:"session_start,attr,omitempty"`
+	LogFile      string         `xml:"log_file,attr,omitempty"`
+	Plugins      []XMLPlugin    `xml:"plugin"`
+	Usage        *XMLUsage      `xml:"usage,omitempty"`
+	Budget       *XMLBudget     `xml:"budget,omitempty"`
+	Skipped      *XMLSkipped    `xml:"skipped,omitempty"`
+	Duplicates   *XMLDuplicates `xml:"duplicates,omitempty"`
+	Errors       *XMLErrors     `xml:"errors,omitempty"`
+	Warnings     *XMLWarnings   `xml:"warnings,omitempty"`
+}
+
+// XMLSkipped lists plugins not run because they do not support the
+// requested mode. Wrapper elements are pointers so they are omitted when empty.
+type XMLSkipped struct {
+	Paths []string `xml:"path"`
+}
+
+// XMLDuplicates lists plugin names reported by more than one plugin.
+type XMLDuplicates struct {
+	Duplicates []XMLDuplicate `xml:"duplicate"`
+}
+
+// XMLDuplicate is a single name conflict and its resolution.
+type XMLDuplicate struct {
+	Name   string          `xml:"name,attr"`
+	Paths  []string        `xml:"path"`
+	Policy DuplicatePolicy `xml:"policy,attr"`
+	Kept   []string        `xml:"kept"`
+}
+
+// XMLErrors lists plugin failures.
+type XMLErrors struct {
+	Errors []XMLError `xml:"error"`
+}
+
+// XMLWarnings lists non-fatal diagnostics.
+type XMLWarnings struct {
+	Warnings []string `xml:"warning"`
+}
+
+// XMLPlugin is a single plugin's entry in XMLResults.
+type XMLPlugin struct {
+	Name             string      `xml:"name,attr"`
+	Version          string      `xml:"version,attr,omitempty"`
+	Cached           bool        `xml:"cached,attr,omitempty"`
+	Attempts         int         `xml:"attempts,attr,omitempty"`
+	Data             XMLData     `xml:"data"`
+	Metrics          *XMLMetrics `xml:"metrics,omitempty"`
+	SchemaViolations []string    `xml:"schema_violation,omitempty"`
+	Stderr           string      `xml:"stderr,omitempty"`
+}
+
+// XMLMetrics is a plugin's metrics object in XMLPlugin.
+type XMLMetrics struct {
+	InputTokenCount    int         `xml:"input_token_count,attr,omitempty"`
+	OutputTokenCount   int         `xml:"output_token_count,attr,omitempty"`
+	ThinkingTokenCount int         `xml:"thinking_token_count,attr,omitempty"`
+	CostEstimateCents  int         `xml:"cost_estimate_cents,attr,omitempty"`
+	OutputSizeBytes    int         `xml:"output_size_bytes,attr,omitempty"`
+	Extra              []XMLMetric `xml:"metric,omitempty"`
+}
+
+// XMLMetric is a non-standard metric, holding its raw JSON value.
+type XMLMetric struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:",chardata"`
+}
+
+// XMLUsage is the metrics totals in XMLResults.
+type XMLUsage struct {
+	InputTokenCount    int    `xml:"input_token_count,attr"`
+	OutputTokenCount   int    `xml:"output_token_count,attr"`
+	ThinkingTokenCount int    `xml:"thinking_token_count,attr"`
+	CostEstimateCents  int    `xml:"cost_estimate_cents,attr"`
+	OutputSizeBytes    int    `xml:"output_size_bytes,attr"`
+	Plugins            int    `xml:"plugins,attr"`
+	Unmetered          string `xml:"unmetered,attr,omitempty"` // Space-separated plugin names
+}
+
+// XMLBudget is the BudgetReport in XMLResults.
+type XMLBudget struct {
+	Policy          BudgetPolicy `xml:"policy,attr"`
+	TokenBudget     int          `xml:"token_budget,attr,omitempty"`
+	Tokens          int          `xml:"tokens,attr"`
+	CostBudgetCents int          `xml:"cost_budget_cents,attr,omitempty"`
+	CostCents       int          `xml:"cost_cents,attr"`
+	Exceeded        bool         `xml:"exceeded,attr"`
+	Dropped         []string     `xml:"dropped"`
+	Truncated       []string     `xml:"truncated"`
+}
+
+// XMLError is a single plugin failure in XMLResults.
+type XMLError struct {
+	Path       string    `xml:"path,attr"`
+	Kind       ErrorKind `xml:"kind,attr"`
+	ExitCode   int       `xml:"exit_code,attr"`
+	DurationMS int64     `xml:"duration_ms,attr"`
+	Attempts   int       `xml:"attempts,attr,omitempty"`
+	Message    string    `xml:"message"`
+	Stderr     string    `xml:"stderr,omitempty"`
+}
+
+// newOutputMeta returns the metadata block for res.
+func newOutputMeta(res *Result) outputMeta {
+	sessionStart := ""
+	if !res.SessionStart.IsZero() {
+		sessionStart = res.SessionStart.UTC().Format(time.RFC3339Nano)
+	}
+	meta := outputMeta{SessionID: res.SessionID, SessionStart: sessionStart, LogFile: res.LogFile, Cached: res.Cached, Skipped: res.Skipped, Duplicates: res.Duplicates, Warnings: res.Warnings, Budget: res.Budget}
+	for _, e := range res.Errors {
+		meta.Errors = append(meta.Errors, errorRecord{PluginError: e, DurationMS: e.Duration.Milliseconds()})
+	}
+	if len(res.Plugins) > 0 {
+		meta.Plugins = make(map[string]pluginMeta, len(res.Plugins))
+		for name, p := range res.Plugins {
+			meta.Plugins[name] = pluginMeta{Version: p.Version, Attempts: res.Attempts[name], Metrics: p.Metrics, SchemaViolations: res.SchemaViolations[name], Stderr: res.Stderr[name]}
+		}
+	}
+	usage := res.Usage()
+	if usage.Plugins > 0 {
+		meta.Usage = &usage
+	}
+	return meta
+}
+
+// reportable reports whether m holds anything beyond the session, the log
+// file and plugin versions: errors, warnings, metrics, or a record of how
+// the plugin data was obtained or cut.
+func (m outputMeta) reportable() bool {
+	if len(m.Errors) > 0 || len(m.Warnings) > 0 || m.Usage != nil || m.Budget != nil ||
+		len(m.Duplicates) > 0 || len(m.Skipped) > 0 || len(m.Cached) > 0 {
+		return true
+	}
+	for _, p := range m.Plugins {
+		if p.Attempts > 0 || p.Metrics != nil || len(p.SchemaViolations) > 0 || p.Stderr != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Format converts the aggregated results to the desired string format.
+func Format(res *Result, opts FormatOptions) (string, error) {
+	order := pluginOrder(res.Plugins, opts.Priority)
+	outputData := make(map[string]any, len(res.Plugins))
+	for name, result := range res.Plugins {
+		var data any
+		if err := json.Unmarshal(result.Data, &data); err != nil {
+			outputData[name] = string(result.Data) // Fallback: output as string
+		} else {
+			outputData[name] = data
+		}
+	}
+	ordered := orderedObject{keys: order, values: outputData}
+	meta := newOutputMeta(res)
+	if meta.reportable() {
+		ordered.keys = append([]string{MetaKey}, order...)
+		outputData[MetaKey] = meta
+	}
+
+	var outputBytes []byte
+	var err error
+	outputFormat := strings.ToLower(opts.Format)
+	indentStr := ""
+	prefixStr := ""
+	if !opts.Summary && opts.Indent > 0 {
+		indentStr = strings.Repeat(" ", opts.Indent)
+		prefixStr = "" // xml/json handle prefix internally via indent
+	}
+
+	switch outputFormat {
+	case "json":
+		if indentStr == "" {
+			outputBytes, err = json.Marshal(ordered)
+		} else {
+			outputBytes, err = json.MarshalIndent(ordered, prefixStr, indentStr)
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal results to JSON: %w", err)
+		}
+	case "xml":
+		xmlRoot := XMLResults{SessionID: res.SessionID, SessionStart: meta.SessionStart, LogFile: res.LogFile}
+		if len(res.Skipped) > 0 {
+			xmlRoot.Skipped = &XMLSkipped{Paths: res.Skipped}
+		}
+		if len(res.Duplicates) > 0 {
+			xmlRoot.Duplicates = &XMLDuplicates{}
+			for _, d := range res.Duplicates {
+				xmlRoot.Duplicates.Duplicates = append(xmlRoot.Duplicates.Duplicates, XMLDuplicate(d))
+			}
+		}
+		if b := res.Budget; b != nil {
+			xmlRoot.Budget = &XMLBudget{
+				Policy:          b.Policy,
+				TokenBudget:     b.TokenBudget,
+				Tokens:          b.Tokens,
+				CostBudgetCents: b.CostBudgetCents,
+				CostCents:       b.CostCents,
+				Exceeded:        b.Exceeded,
+				Dropped:         b.Dropped,
+				Truncated:       b.Truncated,
+			}
+		}
+		if len(res.Warnings) > 0 {
+			xmlRoot.Warnings = &XMLWarnings{Warnings: res.Warnings}
+		}
+		if len(res.Errors) > 0 {
+			xmlRoot.Errors = &XMLErrors{}
+		}
+		structured := true
+		switch opts.XMLData {
+		case "", XMLDataStructured:
+		case XMLDataJSON:
+			structured = false
+		default:
+			return "", fmt.Errorf("invalid XML data mode %q (want %s or %s)", opts.XMLData, XMLDataStructured, XMLDataJSON)
+		}
+		for _, name := range order {
+			meta := res.Plugins[name]                          // Get original metadata
+			data := XMLData{JSON: meta.Data, Structured: true} // Structured data keeps the plugin's key order
+			if !structured {
+				jsonDataBytes, jsonErr := json.Marshal(outputData[name]) // Marshal just the data part
+				if jsonErr != nil {
+					jsonDataBytes = []byte("Error re-marshaling data")
+				}
+				data = XMLData{JSON: jsonDataBytes}
+			}
+			xmlPlugin := XMLPlugin{Name: name, Version: meta.Version, Cached: contains(res.Cached, name), Attempts: res.Attempts[name], Data: data}
+			if meta.Metrics != nil {
+				xmlPlugin.Metrics = xmlMetrics(meta.Metrics)
+			}
+			xmlPlugin.SchemaViolations = res.SchemaViolations[name]
+			xmlPlugin.Stderr = res.Stderr[name]
+			xmlRoot.Plugins = append(xmlRoot.Plugins, xmlPlugin)
+		}
+		if usage := meta.Usage; usage != nil {
+			xmlRoot.Usage = &XMLUsage{
+				InputTokenCount:    usage.InputTokenCount,
+				OutputTokenCount:   usage.OutputTokenCount,
+				ThinkingTokenCount: usage.ThinkingTokenCount,
+				CostEstimateCents:  usage.CostEstimateCents,
+				OutputSizeBytes:    usage.OutputSizeBytes,
+				Plugins:            usage.Plugins,
+				Unmetered:          strings.Join(usage.Unmetered, " "),
+			}
+		}
+		for _, e := range res.Errors {
+			xmlRoot.Errors.Errors = append(xmlRoot.Errors.Errors, XMLError{
+				Path:       e.Path,
+				Kind:       e.Kind,
+				ExitCode:   e.ExitCode,
+				DurationMS: e.Duration.Milliseconds(),
+				Attempts:   e.Attempts,
+				Message:    e.Message,
+				Stderr:     e.Stderr,
+			})
+		}
+		if indentStr == "" {
+			outputBytes, err = xml.Marshal(xmlRoot)
+		} else {
+			outputBytes, err = xml.MarshalIndent(xmlRoot, prefixStr, indentStr)
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal results to XML: %w", err)
+		}
+		// Add XML header manually if needed, MarshalIndent doesn't include it.
+		outputBytes = append([]byte(xml.Header), outputBytes...)
+
+	case "markdown", "md":
+		outputBytes = formatMarkdown(res, order, indentStr)
+
+	case "prompt":
+		outputBytes = formatPrompt(res, order, indentStr)
+
+	case "ndjson":
+		outputBytes, err = formatNDJSON(res, order)
+		if err != nil {
+			return "", fmt.Errorf("failed to write results as NDJSON: %w", err)
+		}
+
+	case "txtar":
+		outputBytes, err = formatTxtar(res, order, meta, indentStr)
+		if err != nil {
+			return "", fmt.Errorf("failed to write results as txtar: %w", err)
+		}
+
+	case "yaml":
+		fallthrough
+	default: // Default to YAML
+		// YAML marshaller doesn't support indentation control easily in the standard lib
+		outputBytes, err = ordered.marshalYAML()
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal results to YAML: %w", err)
+		}
+	}
+
+	// Add trailing newline if not empty and not already present
+	if len(outputBytes) > 0 && !bytes.HasSuffix(outputBytes, []byte("\n")) {
+		return string(outputBytes) + "\n", nil
+	}
+	return string(outputBytes), nil
+}
+
+// orderedObject is a JSON object whose keys are emitted in a fixed order
+// rather than sorted, as encoding/json does for maps.
+type orderedObject struct {
+	keys   []string
+	values map[string]any
+}
+
+func (o orderedObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(key)
+		v, err := json.Marshal(o.values[key])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// marshalYAML renders the object as a YAML mapping in key order. Values go
+// through JSON, as with sigs.k8s.io/yaml, so nested maps are sorted and
+// number types preserved.
+func (o orderedObject) marshalYAML() ([]byte, error) {
+	doc := make(goyaml.MapSlice, 0, len(o.keys))
+	for _, key := range o.keys {
+		j, err := json.Marshal(o.values[key])
+		if err != nil {
+			return nil, err
+		}
+		var v any
+		if err := goyaml.Unmarshal(j, &v); err != nil {
+			return nil, err
+		}
+		doc = append(doc, goyaml.MapItem{Key: key, Value: v})
+	}
+	return goyaml.Marshal(doc)
+}
+
+// xmlMetrics converts plugin metrics for XML output, ordering extra
+// metrics by name.
+func xmlMetrics(m *Metrics) *XMLMetrics {
+	x := &XMLMetrics{
+		InputTokenCount:    m.InputTokenCount,
+		OutputTokenCount:   m.OutputTokenCount,
+		ThinkingTokenCount: m.ThinkingTokenCount,
+		CostEstimateCents:  m.CostEstimateCents,
+		OutputSizeBytes:    m.OutputSizeBytes,
+	}
+	names := make([]string, 0, len(m.Extra))
+	for name := range m.Extra {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		x.Extra = append(x.Extra, XMLMetric{Name: name, Value: string(m.Extra[name])})
+	}
+	return x
+}
+
+// contains reports whether list contains s.
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,54 @@
+package ctxrun
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestFormatMetaOnlyWhenReportable(t *testing.T) {
+	clean := func() *Result {
+		return &Result{
+			SessionID: "01JGQ0M5K8ZZZZZZZZZZZZZZZZ",
+			LogFile:   "/state/ctx/logs/01JGQ0M5K8ZZZZZZZZZZZZZZZZ.log",
+			Plugins:   map[string]PluginData{"git": {Version: "1", Data: json.RawMessage(`{"branch":"main"}`)}},
+		}
+	}
+	tests := []struct {
+		name     string
+		modify   func(*Result)
+		wantMeta bool
+	}{
+		{"clean run", func(*Result) {}, false},
+		{"no plugins", func(r *Result) { r.Plugins = map[string]PluginData{} }, false},
+		{"error", func(r *Result) { r.Errors = []*PluginError{{Path: "/bin/ctx-env", Kind: ErrorKindExec, ExitCode: 1}} }, true},
+		{"warning", func(r *Result) { r.Warnings = []string{"shadowed"} }, true},
+		{"metrics", func(r *Result) {
+			p := r.Plugins["git"]
+			p.Metrics = &Metrics{OutputTokenCount: 5}
+			r.Plugins["git"] = p
+		}, true},
+		{"budget", func(r *Result) { r.Budget = &BudgetReport{Policy: BudgetDrop} }, true},
+		{"cached", func(r *Result) { r.Cached = []string{"git"} }, true},
+		{"retried", func(r *Result) { r.Attempts = map[string]int{"git": 2} }, true},
+		{"schema violation", func(r *Result) { r.SchemaViolations = map[string][]string{"git": {"bad"}} }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := clean()
+			tt.modify(res)
+			for _, format := range []string{"json", "yaml"} {
+				out, err := Format(res, FormatOptions{Format: format})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := strings.Contains(out, MetaKey); got != tt.wantMeta {
+					t.Errorf("%s output has %s: %v, want %v:\n%s", format, MetaKey, got, tt.wantMeta, out)
+				}
+				if !strings.Contains(out, "main") && len(res.Plugins) > 0 {
+					t.Errorf("%s output lacks the plugin data:\n%s", format, out)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package ctxrun
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/fnv"
+	"io/fs"
+	"path/filepath"
+	"time"
+)
+
+// DefaultPollInterval is how often Watch scans the watched directory where
+// native file notifications are unavailable.
+const DefaultPollInterval = time.Second
+
+// ignoredWatchDirs are directories whose contents never trigger a re-run:
+// VCS metadata, which plugins may touch while running, and dependency trees
+// too large to watch.
+var ignoredWatchDirs = map[string]bool{".git": true, ".hg": true, ".svn": true, "node_modules": true}
+
+// watchDir returns a channel that receives a value when files under dir
+// change, until ctx is done. Bursts of changes may be coalesced. Native
+// notifications are used where available (see notifyDir); otherwise dir is
+// scanned every poll interval.
+func (r *Runner) watchDir(ctx context.Context, dir string, poll time.Duration) (<-chan struct{}, error) {
+	changes := make(chan struct{}, 1)
+	err := notifyDir(ctx, dir, changes)
+	if err == nil {
+		return changes, nil
+	}
+	if poll <= 0 {
+		poll = DefaultPollInterval
+	}
+	r.logf("Native file notifications unavailable (%v); polling %s every %s.", err, dir, poll)
+	last, err := snapshotDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		t := time.NewTicker(poll)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+			if snap, err := snapshotDir(dir); err == nil && snap != last {
+				last = snap
+				signalChange(changes)
+			}
+		}
+	}()
+	return changes, nil
+}
+
+// signalChange sends on changes unless a change is already pending.
+func signalChange(changes chan<- struct{}) {
+	select {
+	case changes <- struct{}{}:
+	default:
+	}
+}
+
+// snapshotDir hashes the names, sizes, modes and modification times of the
+// files under dir. Entries that cannot be read are skipped.
+func snapshotDir(dir string) (uint64, error) {
+	h := fnv.New64a()
+	var buf [8]byte
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == dir {
+				return err
+			}
+			return nil
+		}
+		if d.IsDir() && path != dir && ignoredWatchDirs[d.Name()] {
+			return filepath.SkipDir
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		h.Write([]byte(path))
+		for _, v := range []uint64{uint64(info.Size()), uint64(info.Mode()), uint64(info.ModTime().UnixNano())} {
+			binary.LittleEndian.PutUint64(buf[:], v)
+			h.Write(buf[:])
+		}
+		return nil
+	})
+	return h.Sum64(), err
+}
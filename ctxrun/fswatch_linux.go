@@ -0,0 +1,105 @@
+//go:build linux
+
+package ctxrun
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MODIFY | syscall.IN_ATTRIB |
+	syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO
+
+// inotify watches a directory tree. inotify watches are not recursive, so
+// each directory is added, including ones created later.
+type inotify struct {
+	fd   int
+	f    *os.File       // fd, registered with the runtime poller so Close interrupts Read
+	dirs map[int]string // Watch descriptor to directory
+}
+
+// notifyDir sends on changes when files under dir change, until ctx is
+// done, using inotify.
+func notifyDir(ctx context.Context, dir string, changes chan<- struct{}) error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return os.NewSyscallError("inotify_init1", err)
+	}
+	w := &inotify{fd: fd, f: os.NewFile(uintptr(fd), "inotify"), dirs: make(map[int]string)}
+	if err := w.addTree(dir); err != nil {
+		w.f.Close()
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		w.f.Close()
+	}()
+	go w.run(changes)
+	return nil
+}
+
+// addTree watches root and the directories below it.
+func (w *inotify) addTree(root string) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			return nil
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if path != root && ignoredWatchDirs[d.Name()] {
+			return filepath.SkipDir
+		}
+		wd, err := syscall.InotifyAddWatch(w.fd, path, inotifyMask)
+		if err != nil {
+			return os.NewSyscallError("inotify_add_watch", err) // Typically the watch limit; fall back to polling
+		}
+		w.dirs[wd] = path
+		return nil
+	})
+}
+
+// run reads events until the inotify file is closed.
+func (w *inotify) run(changes chan<- struct{}) {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := w.f.Read(buf)
+		if err != nil {
+			return
+		}
+		changed := false
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+			ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			nameStart := off + syscall.SizeofInotifyEvent
+			off = nameStart + int(ev.Len)
+			if off > n {
+				break
+			}
+			name := strings.TrimRight(string(buf[nameStart:off]), "\x00")
+			switch {
+			case ev.Mask&syscall.IN_Q_OVERFLOW != 0:
+				changed = true
+			case ev.Mask&syscall.IN_IGNORED != 0:
+				delete(w.dirs, int(ev.Wd))
+			case ignoredWatchDirs[name]:
+			default:
+				changed = true
+				dir, ok := w.dirs[int(ev.Wd)]
+				if ok && ev.Mask&syscall.IN_ISDIR != 0 && ev.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+					w.addTree(filepath.Join(dir, name)) // Best effort; the change is reported regardless
+				}
+			}
+		}
+		if changed {
+			signalChange(changes)
+		}
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package ctxrun
+
+import (
+	"context"
+	"errors"
+)
+
+// notifyDir is not implemented on this platform, so watchDir polls.
+func notifyDir(ctx context.Context, dir string, changes chan<- struct{}) error {
+	return errors.New("not supported on this platform")
+}
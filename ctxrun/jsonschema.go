@@ -0,0 +1,410 @@
+package ctxrun
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxSchemaViolations caps the violations reported per plugin.
+const maxSchemaViolations = 20
+
+// ValidateSchema checks data against a JSON Schema and returns the
+// violations found, each prefixed with the JSON Pointer of the offending
+// value. It implements the commonly used subset of draft 2020-12:
+// type, enum, const, numeric and string bounds, pattern, items,
+// prefixItems, array bounds, uniqueItems, properties, required,
+// patternProperties, additionalProperties, property-count bounds, allOf,
+// anyOf, oneOf, not, if/then/else, and local $ref ("#", "#/$defs/...").
+// Unknown keywords, including format, are ignored.
+func ValidateSchema(schema, data json.RawMessage) ([]string, error) {
+	var root, inst any
+	if err := json.Unmarshal(schema, &root); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	if err := json.Unmarshal(data, &inst); err != nil {
+		return nil, fmt.Errorf("invalid data: %w", err)
+	}
+	v := &schemaValidator{root: root, patterns: map[string]*regexp.Regexp{}}
+	v.validate(root, inst, "", 0)
+	if v.err != nil {
+		return nil, v.err
+	}
+	return v.violations, nil
+}
+
+type schemaValidator struct {
+	root       any
+	patterns   map[string]*regexp.Regexp
+	violations []string
+	err        error // Problem with the schema itself
+}
+
+// maxRefDepth guards against reference cycles.
+const maxRefDepth = 64
+
+func (v *schemaValidator) fail(path, format string, args ...any) {
+	if len(v.violations) < maxSchemaViolations {
+		if path == "" {
+			path = "/"
+		}
+		v.violations = append(v.violations, path+": "+fmt.Sprintf(format, args...))
+	}
+}
+
+// check validates inst against s without recording violations, reporting
+// whether it is valid. Used by anyOf, oneOf, not and if.
+func (v *schemaValidator) check(s, inst any, path string, depth int) bool {
+	saved := v.violations
+	v.violations = nil
+	v.validate(s, inst, path, depth)
+	ok := len(v.violations) == 0
+	v.violations = saved
+	return ok
+}
+
+func (v *schemaValidator) validate(s, inst any, path string, depth int) {
+	if v.err != nil {
+		return
+	}
+	switch s := s.(type) {
+	case bool:
+		if !s {
+			v.fail(path, "no value is allowed here")
+		}
+		return
+	case map[string]any:
+		v.validateObject(s, inst, path, depth)
+	default:
+		v.err = fmt.Errorf("invalid schema at %q: want object or boolean", path)
+	}
+}
+
+func (v *schemaValidator) validateObject(s map[string]any, inst any, path string, depth int) {
+	if ref, ok := s["$ref"].(string); ok {
+		if depth >= maxRefDepth {
+			v.err = fmt.Errorf("$ref nesting too deep at %q", ref)
+			return
+		}
+		target, err := v.resolve(ref)
+		if err != nil {
+			v.err = err
+			return
+		}
+		v.validate(target, inst, path, depth+1)
+	}
+
+	if t, ok := s["type"]; ok {
+		var types []string
+		switch t := t.(type) {
+		case string:
+			types = []string{t}
+		case []any:
+			for _, e := range t {
+				if str, ok := e.(string); ok {
+					types = append(types, str)
+				}
+			}
+		}
+		matched := false
+		for _, typ := range types {
+			if hasType(inst, typ) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			v.fail(path, "expected %s, got %s", strings.Join(types, " or "), typeOf(inst))
+			return // Further keywords would only repeat the type error
+		}
+	}
+	if enum, ok := s["enum"].([]any); ok {
+		found := false
+		for _, e := range enum {
+			if reflect.DeepEqual(e, inst) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			v.fail(path, "value is not one of the allowed values")
+		}
+	}
+	if c, ok := s["const"]; ok && !reflect.DeepEqual(c, inst) {
+		v.fail(path, "value does not equal the required constant")
+	}
+
+	switch inst := inst.(type) {
+	case float64:
+		v.validateNumber(s, inst, path)
+	case string:
+		v.validateString(s, inst, path)
+	case []any:
+		v.validateArray(s, inst, path, depth)
+	case map[string]any:
+		v.validateProperties(s, inst, path, depth)
+	}
+
+	if all, ok := s["allOf"].([]any); ok {
+		for _, sub := range all {
+			v.validate(sub, inst, path, depth)
+		}
+	}
+	if anyOf, ok := s["anyOf"].([]any); ok {
+		matched := false
+		for _, sub := range anyOf {
+			if v.check(sub, inst, path, depth) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			v.fail(path, "value does not match any schema in anyOf")
+		}
+	}
+	if oneOf, ok := s["oneOf"].([]any); ok {
+		n := 0
+		for _, sub := range oneOf {
+			if v.check(sub, inst, path, depth) {
+				n++
+			}
+		}
+		if n != 1 {
+			v.fail(path, "value matches %d schemas in oneOf, want exactly 1", n)
+		}
+	}
+	if not, ok := s["not"]; ok && v.check(not, inst, path, depth) {
+		v.fail(path, "value must not match the schema in not")
+	}
+	if cond, ok := s["if"]; ok {
+		if v.check(cond, inst, path, depth) {
+			if then, ok := s["then"]; ok {
+				v.validate(then, inst, path, depth)
+			}
+		} else if els, ok := s["else"]; ok {
+			v.validate(els, inst, path, depth)
+		}
+	}
+}
+
+func (v *schemaValidator) validateNumber(s map[string]any, n float64, path string) {
+	if min, ok := s["minimum"].(float64); ok && n < min {
+		v.fail(path, "%v is less than minimum %v", n, min)
+	}
+	if max, ok := s["maximum"].(float64); ok && n > max {
+		v.fail(path, "%v is greater than maximum %v", n, max)
+	}
+	if min, ok := s["exclusiveMinimum"].(float64); ok && n <= min {
+		v.fail(path, "%v is not greater than %v", n, min)
+	}
+	if max, ok := s["exclusiveMaximum"].(float64); ok && n >= max {
+		v.fail(path, "%v is not less than %v", n, max)
+	}
+	if m, ok := s["multipleOf"].(float64); ok && m > 0 && !isMultiple(n, m) {
+		v.fail(path, "%v is not a multiple of %v", n, m)
+	}
+}
+
+// isMultiple reports whether n is an integer multiple of m. Both are taken
+// at their shortest decimal representation and divided exactly, so that
+// 0.3 is a multiple of 0.1 as the JSON text says, despite binary rounding.
+func isMultiple(n, m float64) bool {
+	rn, ok1 := new(big.Rat).SetString(strconv.FormatFloat(n, 'g', -1, 64))
+	rm, ok2 := new(big.Rat).SetString(strconv.FormatFloat(m, 'g', -1, 64))
+	if !ok1 || !ok2 {
+		return false
+	}
+	return rn.Quo(rn, rm).IsInt()
+}
+
+func (v *schemaValidator) validateString(s map[string]any, str, path string) {
+	n := utf8.RuneCountInString(str)
+	if min, ok := s["minLength"].(float64); ok && float64(n) < min {
+		v.fail(path, "string shorter than minLength %v", min)
+	}
+	if max, ok := s["maxLength"].(float64); ok && float64(n) > max {
+		v.fail(path, "string longer than maxLength %v", max)
+	}
+	if pattern, ok := s["pattern"].(string); ok {
+		re, err := v.compile(pattern)
+		if err != nil {
+			v.err = err
+			return
+		}
+		if !re.MatchString(str) {
+			v.fail(path, "string does not match pattern %q", pattern)
+		}
+	}
+}
+
+func (v *schemaValidator) validateArray(s map[string]any, arr []any, path string, depth int) {
+	if min, ok := s["minItems"].(float64); ok && float64(len(arr)) < min {
+		v.fail(path, "array has fewer than %v items", min)
+	}
+	if max, ok := s["maxItems"].(float64); ok && float64(len(arr)) > max {
+		v.fail(path, "array has more than %v items", max)
+	}
+	if unique, ok := s["uniqueItems"].(bool); ok && unique {
+		for i := range arr {
+			for j := i + 1; j < len(arr); j++ {
+				if reflect.DeepEqual(arr[i], arr[j]) {
+					v.fail(path, "items %d and %d are equal", i, j)
+				}
+			}
+		}
+	}
+	prefix, _ := s["prefixItems"].([]any)
+	for i, sub := range prefix {
+		if i < len(arr) {
+			v.validate(sub, arr[i], path+"/"+strconv.Itoa(i), depth)
+		}
+	}
+	if items, ok := s["items"]; ok {
+		for i := len(prefix); i < len(arr); i++ {
+			v.validate(items, arr[i], path+"/"+strconv.Itoa(i), depth)
+		}
+	}
+}
+
+func (v *schemaValidator) validateProperties(s map[string]any, obj map[string]any, path string, depth int) {
+	if min, ok := s["minProperties"].(float64); ok && float64(len(obj)) < min {
+		v.fail(path, "object has fewer than %v properties", min)
+	}
+	if max, ok := s["maxProperties"].(float64); ok && float64(len(obj)) > max {
+		v.fail(path, "object has more than %v properties", max)
+	}
+	if required, ok := s["required"].([]any); ok {
+		for _, r := range required {
+			if name, ok := r.(string); ok {
+				if _, present := obj[name]; !present {
+					v.fail(path, "missing required property %q", name)
+				}
+			}
+		}
+	}
+
+	// Visit properties in order so violations are reported deterministically.
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	props, _ := s["properties"].(map[string]any)
+	patternProps, _ := s["patternProperties"].(map[string]any)
+	additional, hasAdditional := s["additionalProperties"]
+	for _, k := range keys {
+		childPath := path + "/" + escapePointer(k)
+		matched := false
+		if sub, ok := props[k]; ok {
+			matched = true
+			v.validate(sub, obj[k], childPath, depth)
+		}
+		for pattern, sub := range patternProps {
+			re, err := v.compile(pattern)
+			if err != nil {
+				v.err = err
+				return
+			}
+			if re.MatchString(k) {
+				matched = true
+				v.validate(sub, obj[k], childPath, depth)
+			}
+		}
+		if !matched && hasAdditional {
+			if b, ok := additional.(bool); ok && !b {
+				v.fail(path, "additional property %q is not allowed", k)
+			} else {
+				v.validate(additional, obj[k], childPath, depth)
+			}
+		}
+	}
+}
+
+// resolve follows a local $ref such as "#" or "#/$defs/item".
+func (v *schemaValidator) resolve(ref string) (any, error) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, fmt.Errorf("unsupported $ref %q: only local references are supported", ref)
+	}
+	node := v.root
+	pointer := strings.TrimPrefix(ref, "#")
+	if pointer == "" {
+		return node, nil
+	}
+	for _, tok := range strings.Split(strings.TrimPrefix(pointer, "/"), "/") {
+		tok = strings.ReplaceAll(strings.ReplaceAll(tok, "~1", "/"), "~0", "~")
+		switch n := node.(type) {
+		case map[string]any:
+			next, ok := n[tok]
+			if !ok {
+				return nil, fmt.Errorf("unresolvable $ref %q", ref)
+			}
+			node = next
+		case []any:
+			i, err := strconv.Atoi(tok)
+			if err != nil || i < 0 || i >= len(n) {
+				return nil, fmt.Errorf("unresolvable $ref %q", ref)
+			}
+			node = n[i]
+		default:
+			return nil, fmt.Errorf("unresolvable $ref %q", ref)
+		}
+	}
+	return node, nil
+}
+
+func (v *schemaValidator) compile(pattern string) (*regexp.Regexp, error) {
+	if re, ok := v.patterns[pattern]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %q in schema: %w", pattern, err)
+	}
+	v.patterns[pattern] = re
+	return re, nil
+}
+
+// hasType reports whether inst is of the named JSON Schema type.
+func hasType(inst any, typ string) bool {
+	switch typ {
+	case "integer":
+		n, ok := inst.(float64)
+		return ok && n == math.Trunc(n)
+	case "number":
+		_, ok := inst.(float64)
+		return ok
+	}
+	return typeOf(inst) == typ
+}
+
+// typeOf names the JSON type of a decoded value.
+func typeOf(inst any) string {
+	switch inst.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return "unknown"
+}
+
+// escapePointer escapes a property name for use in a JSON Pointer.
+func escapePointer(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "~", "~0"), "/", "~1")
+}
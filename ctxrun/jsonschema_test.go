@@ -0,0 +1,204 @@
+package ctxrun
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateSchema(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		data   string
+		want   []string // Violations, in order; nil means valid
+	}{
+		{"true schema", `true`, `{"a":1}`, nil},
+		{"false schema", `false`, `1`, []string{"/: no value is allowed here"}},
+		{"empty schema", `{}`, `[1,"x",null]`, nil},
+
+		{"type match", `{"type":"string"}`, `"x"`, nil},
+		{"type mismatch", `{"type":"string"}`, `1`, []string{"/: expected string, got number"}},
+		{"type list", `{"type":["string","null"]}`, `null`, nil},
+		{"type list mismatch", `{"type":["string","null"]}`, `true`, []string{"/: expected string or null, got boolean"}},
+		{"integer", `{"type":"integer"}`, `3`, nil},
+		{"integer with fraction", `{"type":"integer"}`, `3.5`, []string{"/: expected integer, got number"}},
+		{"number accepts integer", `{"type":"number"}`, `3`, nil},
+		{"array", `{"type":"array"}`, `[]`, nil},
+		{"object", `{"type":"object"}`, `[]`, []string{"/: expected object, got array"}},
+
+		{"enum match", `{"enum":["a",1,null]}`, `1`, nil},
+		{"enum mismatch", `{"enum":["a",1]}`, `"b"`, []string{"/: value is not one of the allowed values"}},
+		{"const match", `{"const":{"a":[1]}}`, `{"a":[1]}`, nil},
+		{"const mismatch", `{"const":"a"}`, `"b"`, []string{"/: value does not equal the required constant"}},
+
+		{"minimum", `{"minimum":1}`, `1`, nil},
+		{"below minimum", `{"minimum":1}`, `0.5`, []string{"/: 0.5 is less than minimum 1"}},
+		{"maximum", `{"maximum":1}`, `1`, nil},
+		{"above maximum", `{"maximum":1}`, `2`, []string{"/: 2 is greater than maximum 1"}},
+		{"exclusiveMinimum", `{"exclusiveMinimum":1}`, `1`, []string{"/: 1 is not greater than 1"}},
+		{"exclusiveMaximum", `{"exclusiveMaximum":1}`, `0.9`, nil},
+		{"at exclusiveMaximum", `{"exclusiveMaximum":1}`, `1`, []string{"/: 1 is not less than 1"}},
+		{"multipleOf integer", `{"multipleOf":3}`, `9`, nil},
+		{"not multipleOf integer", `{"multipleOf":3}`, `10`, []string{"/: 10 is not a multiple of 3"}},
+		{"multipleOf decimal", `{"multipleOf":0.1}`, `0.3`, nil},
+		{"multipleOf small decimal", `{"multipleOf":0.01}`, `19.99`, nil},
+		{"not multipleOf decimal", `{"multipleOf":0.1}`, `0.35`, []string{"/: 0.35 is not a multiple of 0.1"}},
+		{"bounds ignore strings", `{"minimum":5}`, `"x"`, nil},
+
+		{"minLength", `{"minLength":2}`, `"ab"`, nil},
+		{"below minLength", `{"minLength":2}`, `"a"`, []string{"/: string shorter than minLength 2"}},
+		{"maxLength counts runes", `{"maxLength":2}`, `"éé"`, nil},
+		{"above maxLength", `{"maxLength":2}`, `"abc"`, []string{"/: string longer than maxLength 2"}},
+		{"pattern", `{"pattern":"^v[0-9]+$"}`, `"v12"`, nil},
+		{"pattern mismatch", `{"pattern":"^v[0-9]+$"}`, `"12"`, []string{`/: string does not match pattern "^v[0-9]+$"`}},
+
+		{"items", `{"items":{"type":"number"}}`, `[1,2]`, nil},
+		{"items mismatch", `{"items":{"type":"number"}}`, `[1,"x"]`, []string{"/1: expected number, got string"}},
+		{"prefixItems", `{"prefixItems":[{"type":"string"}],"items":{"type":"number"}}`, `["a",1]`, nil},
+		{"prefixItems mismatch", `{"prefixItems":[{"type":"string"}],"items":{"type":"number"}}`, `[1,"a"]`, []string{"/0: expected string, got number", "/1: expected number, got string"}},
+		{"items false", `{"prefixItems":[true],"items":false}`, `[1,2]`, []string{"/1: no value is allowed here"}},
+		{"minItems", `{"minItems":1}`, `[]`, []string{"/: array has fewer than 1 items"}},
+		{"maxItems", `{"maxItems":1}`, `[1,2]`, []string{"/: array has more than 1 items"}},
+		{"uniqueItems", `{"uniqueItems":true}`, `[1,{"a":1}]`, nil},
+		{"uniqueItems duplicate", `{"uniqueItems":true}`, `[{"a":1},2,{"a":1}]`, []string{"/: items 0 and 2 are equal"}},
+
+		{"required", `{"required":["a"]}`, `{"a":null}`, nil},
+		{"missing required", `{"required":["a","b"]}`, `{"a":1}`, []string{`/: missing required property "b"`}},
+		{"properties", `{"properties":{"a":{"type":"string"}}}`, `{"a":"x","b":1}`, nil},
+		{"properties mismatch", `{"properties":{"a/b":{"type":"string"}}}`, `{"a/b":1}`, []string{"/a~1b: expected string, got number"}},
+		{"patternProperties", `{"patternProperties":{"^x-":{"type":"number"}}}`, `{"x-a":"s","y":"s"}`, []string{"/x-a: expected number, got string"}},
+		{"additionalProperties false", `{"properties":{"a":true},"additionalProperties":false}`, `{"a":1,"b":2}`, []string{`/: additional property "b" is not allowed`}},
+		{"additionalProperties schema", `{"patternProperties":{"^a":true},"additionalProperties":{"type":"number"}}`, `{"ab":"x","c":"y"}`, []string{"/c: expected number, got string"}},
+		{"minProperties", `{"minProperties":1}`, `{}`, []string{"/: object has fewer than 1 properties"}},
+		{"maxProperties", `{"maxProperties":1}`, `{"a":1,"b":2}`, []string{"/: object has more than 1 properties"}},
+
+		{"allOf", `{"allOf":[{"minimum":1},{"maximum":3}]}`, `2`, nil},
+		{"allOf mismatch", `{"allOf":[{"minimum":1},{"maximum":3}]}`, `4`, []string{"/: 4 is greater than maximum 3"}},
+		{"anyOf", `{"anyOf":[{"type":"string"},{"type":"number"}]}`, `1`, nil},
+		{"anyOf mismatch", `{"anyOf":[{"type":"string"},{"type":"number"}]}`, `null`, []string{"/: value does not match any schema in anyOf"}},
+		{"oneOf", `{"oneOf":[{"type":"integer"},{"type":"string"}]}`, `1`, nil},
+		{"oneOf several", `{"oneOf":[{"type":"integer"},{"type":"number"}]}`, `1`, []string{"/: value matches 2 schemas in oneOf, want exactly 1"}},
+		{"oneOf none", `{"oneOf":[{"type":"integer"}]}`, `"x"`, []string{"/: value matches 0 schemas in oneOf, want exactly 1"}},
+		{"not", `{"not":{"type":"null"}}`, `1`, nil},
+		{"not mismatch", `{"not":{"type":"null"}}`, `null`, []string{"/: value must not match the schema in not"}},
+		{"if then", `{"if":{"type":"string"},"then":{"minLength":2},"else":{"minimum":0}}`, `"a"`, []string{"/: string shorter than minLength 2"}},
+		{"if else", `{"if":{"type":"string"},"then":{"minLength":2},"else":{"minimum":0}}`, `-1`, []string{"/: -1 is less than minimum 0"}},
+
+		{"ref to defs", `{"$defs":{"n":{"type":"number"}},"properties":{"a":{"$ref":"#/$defs/n"}}}`, `{"a":"x"}`, []string{"/a: expected number, got string"}},
+		{"ref to root", `{"type":"object","properties":{"child":{"$ref":"#"}}}`, `{"child":{"child":1}}`, []string{"/child/child: expected object, got number"}},
+		{"ref escaped", `{"$defs":{"a/b":{"const":1}},"$ref":"#/$defs/a~1b"}`, `1`, nil},
+		{"ref and siblings", `{"$defs":{"s":{"type":"string"}},"$ref":"#/$defs/s","minLength":2}`, `"a"`, []string{"/: string shorter than minLength 2"}},
+
+		{"unknown keywords ignored", `{"format":"email","x-custom":{"type":"number"}}`, `"not an email"`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ValidateSchema(json.RawMessage(tt.schema), json.RawMessage(tt.data))
+			if err != nil {
+				t.Fatalf("ValidateSchema: %v", err)
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("violations:\n got %q\nwant %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateSchemaViolationCap(t *testing.T) {
+	data := "[" + strings.Repeat("1,", 2*maxSchemaViolations) + "1]"
+	got, err := ValidateSchema(json.RawMessage(`{"items":{"type":"string"}}`), json.RawMessage(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != maxSchemaViolations {
+		t.Errorf("got %d violations, want %d", len(got), maxSchemaViolations)
+	}
+}
+
+func TestValidateSchemaErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		data   string
+		want   string // Substring of the error
+	}{
+		{"invalid schema JSON", `{`, `1`, "invalid schema"},
+		{"invalid data JSON", `{}`, `{`, "invalid data"},
+		{"schema not an object", `1`, `1`, "want object or boolean"},
+		{"nested schema not an object", `{"items":"x"}`, `[1]`, "want object or boolean"},
+		{"remote ref", `{"$ref":"https://example.com/s.json"}`, `1`, "only local references are supported"},
+		{"unresolvable ref", `{"$ref":"#/$defs/missing"}`, `1`, "unresolvable $ref"},
+		{"ref cycle", `{"$defs":{"a":{"$ref":"#/$defs/a"}},"$ref":"#/$defs/a"}`, `1`, "nesting too deep"},
+		{"invalid pattern", `{"pattern":"("}`, `"x"`, "invalid pattern"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ValidateSchema(json.RawMessage(tt.schema), json.RawMessage(tt.data))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("ValidateSchema error = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestPluginSchemaURL(t *testing.T) {
+	dir := t.TempDir()
+	pluginDir := filepath.Join(dir, "plugins")
+	if err := os.Mkdir(pluginDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	pluginPath := filepath.Join(pluginDir, "ctx-test")
+	inside := filepath.Join(pluginDir, "schema.json")
+	outside := filepath.Join(dir, "secret.json")
+	for _, p := range []string{inside, outside} {
+		if err := os.WriteFile(p, []byte(`{"type":"object"}`), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	link := filepath.Join(pluginDir, "link.json")
+	if err := os.Symlink(outside, link); err != nil {
+		t.Fatal(err)
+	}
+
+	r := New(WithCacheDir(filepath.Join(dir, "cache")))
+	tests := []struct {
+		url     string
+		wantErr string // Empty if the schema should load
+	}{
+		{"file://" + inside, ""},
+		{"file://" + outside, "outside the plugin directory"},
+		{"file://" + filepath.Join(pluginDir, "..", "secret.json"), "outside the plugin directory"},
+		{"file://" + link, "outside the plugin directory"},
+		{"https://example.com/schema.json", "is not cached"},
+		{"ftp://example.com/schema.json", "unsupported data_schema_url scheme"},
+		{"/etc/passwd", "unsupported data_schema_url scheme"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			schema, err := r.pluginSchema(pluginPath, PluginData{DataSchemaURL: tt.url})
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("pluginSchema: %v", err)
+			case tt.wantErr == "" && string(schema) != `{"type":"object"}`:
+				t.Errorf("schema = %s", schema)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("pluginSchema error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	cached := r.SchemaCachePath("https://example.com/schema.json")
+	if err := os.MkdirAll(filepath.Dir(cached), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(cached, []byte(`{"type":"array"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	schema, err := r.pluginSchema(pluginPath, PluginData{DataSchemaURL: "https://example.com/schema.json"})
+	if err != nil || string(schema) != `{"type":"array"}` {
+		t.Errorf("cached schema = %s, %v", schema, err)
+	}
+}
@@ -0,0 +1,59 @@
+package ctxrun
+
+import (
+	"sync"
+	"time"
+)
+
+// ResultCache holds plugin results in memory for up to a maximum age, so
+// that Runners sharing it, such as those serving requests in a long-running
+// process, reuse results regardless of cache_info. Results of plugins that
+// return cache_info with cacheable: false, or that needed approval, are not
+// held. It is keyed like the host-side cache and safe for concurrent use.
+type ResultCache struct {
+	maxAge time.Duration
+
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+}
+
+type memoryEntry struct {
+	data    PluginData
+	expires time.Time
+}
+
+// NewResultCache returns a ResultCache keeping results for maxAge.
+func NewResultCache(maxAge time.Duration) *ResultCache {
+	return &ResultCache{maxAge: maxAge, entries: make(map[string]memoryEntry)}
+}
+
+// get returns the fresh result stored under key. A nil cache holds nothing.
+func (c *ResultCache) get(key string) (PluginData, bool) {
+	if c == nil {
+		return PluginData{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok || !time.Now().Before(e.expires) {
+		delete(c.entries, key)
+		return PluginData{}, false
+	}
+	return e.data, true
+}
+
+// put stores data under key if the plugin allows it.
+func (c *ResultCache) put(key string, data PluginData) {
+	if c == nil || c.maxAge <= 0 || data.approved || (data.CacheInfo != nil && !data.CacheInfo.Cacheable) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for k, e := range c.entries { // Drop expired entries so the cache stays bounded by use
+		if !now.Before(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = memoryEntry{data: data, expires: now.Add(c.maxAge)}
+}
@@ -0,0 +1,110 @@
+package ctxrun
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// Metrics is the optional metrics object plugins may report (spec 3.1.1).
+// Standard fields are decoded; any other fields are kept in Extra.
+type Metrics struct {
+	InputTokenCount    int `json:"input_token_count,omitempty"`
+	OutputTokenCount   int `json:"output_token_count,omitempty"`
+	ThinkingTokenCount int `json:"thinking_token_count,omitempty"`
+	CostEstimateCents  int `json:"cost_estimate_cents,omitempty"`
+	OutputSizeBytes    int `json:"output_size_bytes,omitempty"`
+
+	// Extra holds non-standard metrics verbatim.
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// standardMetrics lists the JSON names of the typed Metrics fields.
+var standardMetrics = map[string]bool{
+	"input_token_count":    true,
+	"output_token_count":   true,
+	"thinking_token_count": true,
+	"cost_estimate_cents":  true,
+	"output_size_bytes":    true,
+}
+
+// UnmarshalJSON decodes standard metrics and preserves the rest in Extra.
+func (m *Metrics) UnmarshalJSON(b []byte) error {
+	type plain Metrics
+	if err := json.Unmarshal(b, (*plain)(m)); err != nil {
+		return fmt.Errorf("invalid metrics: %w", err)
+	}
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(b, &all); err != nil {
+		return err
+	}
+	for k, v := range all {
+		if !standardMetrics[k] {
+			if m.Extra == nil {
+				m.Extra = make(map[string]json.RawMessage)
+			}
+			m.Extra[k] = v
+		}
+	}
+	return nil
+}
+
+// MarshalJSON encodes standard metrics followed by Extra.
+func (m Metrics) MarshalJSON() ([]byte, error) {
+	type plain Metrics
+	b, err := json.Marshal(plain(m))
+	if err != nil || len(m.Extra) == 0 {
+		return b, err
+	}
+	all := make(map[string]json.RawMessage, len(m.Extra)+len(standardMetrics))
+	if err := json.Unmarshal(b, &all); err != nil {
+		return nil, err
+	}
+	for k, v := range m.Extra {
+		if !standardMetrics[k] {
+			all[k] = v
+		}
+	}
+	return json.Marshal(all)
+}
+
+// Usage holds metrics totals across the plugins of a run.
+type Usage struct {
+	InputTokenCount    int      `json:"input_token_count"`
+	OutputTokenCount   int      `json:"output_token_count"`
+	ThinkingTokenCount int      `json:"thinking_token_count"`
+	CostEstimateCents  int      `json:"cost_estimate_cents"`
+	OutputSizeBytes    int      `json:"output_size_bytes"`
+	Plugins            int      `json:"plugins"`             // Number of plugins that reported metrics
+	Unmetered          []string `json:"unmetered,omitempty"` // Plugins that reported no metrics, sorted
+}
+
+// add accumulates the standard metrics of m. Extra metrics are not summed.
+func (u *Usage) add(m Metrics) {
+	u.InputTokenCount += m.InputTokenCount
+	u.OutputTokenCount += m.OutputTokenCount
+	u.ThinkingTokenCount += m.ThinkingTokenCount
+	u.CostEstimateCents += m.CostEstimateCents
+	u.OutputSizeBytes += m.OutputSizeBytes
+	u.Plugins++
+}
+
+// String formats the totals as a one-line summary.
+func (u Usage) String() string {
+	return fmt.Sprintf("%d plugin(s) metered: input_tokens=%d output_tokens=%d thinking_tokens=%d cost_cents=%d output_bytes=%d",
+		u.Plugins, u.InputTokenCount, u.OutputTokenCount, u.ThinkingTokenCount, u.CostEstimateCents, u.OutputSizeBytes)
+}
+
+// Usage sums the metrics reported by all successful plugins.
+func (res *Result) Usage() Usage {
+	var u Usage
+	for name, p := range res.Plugins {
+		if p.Metrics == nil {
+			u.Unmetered = append(u.Unmetered, name)
+			continue
+		}
+		u.add(*p.Metrics)
+	}
+	sort.Strings(u.Unmetered)
+	return u
+}
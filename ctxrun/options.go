@@ -0,0 +1,241 @@
+package ctxrun
+
+import (
+	"log"
+	"time"
+)
+
+// An Option configures a Runner.
+type Option func(*Runner)
+
+// WithCacheDir sets the base directory plugins should use for caching
+// (CTX_CACHE_DIR). If unset, $XDG_CACHE_HOME/ctx is used.
+func WithCacheDir(dir string) Option {
+	return func(r *Runner) { r.cacheDir = dir }
+}
+
+// WithNoCache disables the host-side cache of plugin results, which is
+// otherwise used for plugins that return cache_info with cacheable: true.
+func WithNoCache(disable bool) Option {
+	return func(r *Runner) { r.noCache = disable }
+}
+
+// WithRefreshCache makes the Runner ignore cached plugin results while still
+// storing fresh cacheable ones.
+func WithRefreshCache(refresh bool) Option {
+	return func(r *Runner) { r.refreshCache = refresh }
+}
+
+// WithOutputTokenBudget informs plugins of an estimated token budget for
+// their output (CTX_OUTPUT_TOKEN_BUDGET). Zero means unset.
+func WithOutputTokenBudget(tokens int) Option {
+	return func(r *Runner) { r.outputTokenBudget = tokens }
+}
+
+// WithThinkingTokenBudget informs plugins of an estimated token budget for
+// internal work (CTX_THINKING_TOKEN_BUDGET). Zero means unset.
+func WithThinkingTokenBudget(tokens int) Option {
+	return func(r *Runner) { r.thinkingTokenBudget = tokens }
+}
+
+// WithCostBudget informs plugins of an estimated cost budget in USD cents
+// (CTX_COST_BUDGET_CENTS). Zero means unset.
+func WithCostBudget(cents int) Option {
+	return func(r *Runner) { r.costBudgetCents = cents }
+}
+
+// WithAllowedTools sets the comma-separated list of external commands
+// plugins are permitted to call (CTX_ALLOWED_TOOLS).
+func WithAllowedTools(tools string) Option {
+	return func(r *Runner) { r.allowedTools = tools }
+}
+
+// WithPluginTimeout gives each plugin its own deadline, measured from when it
+// starts, and sets CTX_TIMEOUT_SECONDS and CTX_DEADLINE_TIMESTAMP. Zero means
+// no per-plugin timeout.
+func WithPluginTimeout(d time.Duration) Option {
+	return func(r *Runner) { r.pluginTimeout = d }
+}
+
+// WithGlobalTimeout caps the wall-clock time of a whole run. Plugins still
+// running when it expires are stopped; plugins not yet started fail
+// immediately. Zero means no cap.
+func WithGlobalTimeout(d time.Duration) Option {
+	return func(r *Runner) { r.globalTimeout = d }
+}
+
+// WithKillGrace sets how long a timed-out plugin has to exit after SIGTERM
+// before it is sent SIGKILL. Default is DefaultKillGrace.
+func WithKillGrace(d time.Duration) Option {
+	return func(r *Runner) { r.killGrace = d }
+}
+
+// WithPluginRetries sets how many times a plugin is re-run after a retryable
+// failure, and passes the same limit to plugins as CTX_RETRY_MAX. Zero
+// disables retries.
+func WithPluginRetries(n int) Option {
+	return func(r *Runner) { r.pluginRetries = n }
+}
+
+// WithRetryBackoff sets the delay before the first retry. Later retries
+// double it, up to 30s, with jitter. Default is DefaultRetryBackoff.
+func WithRetryBackoff(d time.Duration) Option {
+	return func(r *Runner) { r.retryBackoff = d }
+}
+
+// WithRetryExitCodes sets the exit statuses treated as transient failures.
+// Timeouts and failures reporting {"retryable": true} on stderr are always
+// retryable. Default is DefaultRetryExitCodes.
+func WithRetryExitCodes(codes []int) Option {
+	return func(r *Runner) { r.retryExitCodes = codes }
+}
+
+// WithMaxParallel limits how many plugins run concurrently. Default is 1.
+func WithMaxParallel(n int) Option {
+	return func(r *Runner) { r.maxParallel = n }
+}
+
+// WithShowSource requests plugins to include their source in txtar format
+// (CTX_SHOW_SOURCE=true).
+func WithShowSource(show bool) Option {
+	return func(r *Runner) { r.showSource = show }
+}
+
+// WithAmbientEnvKeys replaces the list of environment variables propagated
+// to plugins when set. Default is DefaultAmbientEnvKeys.
+func WithAmbientEnvKeys(keys []string) Option {
+	return func(r *Runner) { r.ambientEnvKeys = keys }
+}
+
+// WithLogger sets the logger used for diagnostic messages.
+// By default diagnostics are discarded.
+func WithLogger(l *log.Logger) Option {
+	return func(r *Runner) { r.logger = l }
+}
+
+// WithApprover sets the Approver consulted when a plugin returns
+// requires_approval. Default is DenyAll.
+func WithApprover(a Approver) Option {
+	return func(r *Runner) { r.approver = a }
+}
+
+// WithTrust verifies each plugin binary's SHA256, and its reported version,
+// against tf before execution, handling problems according to policy.
+// Default is TrustOff.
+func WithTrust(tf *TrustFile, policy TrustPolicy) Option {
+	return func(r *Runner) {
+		r.trust = tf
+		r.trustPolicy = policy
+	}
+}
+
+// WithCapabilities makes the Runner query each plugin with SpecFlag before
+// running it. Capability documents are cached per plugin binary.
+func WithCapabilities(query bool) Option {
+	return func(r *Runner) { r.queryCapabilities = query }
+}
+
+// WithMode requests a plugin mode, skipping plugins whose capability
+// document lists modes that do not include it. Implies WithCapabilities.
+func WithMode(mode string) Option {
+	return func(r *Runner) { r.mode = mode }
+}
+
+// WithBudgetPolicy sets how the output token and cost budgets are enforced.
+// Default is BudgetNone, which only reports usage.
+func WithBudgetPolicy(p BudgetPolicy) Option {
+	return func(r *Runner) { r.budgetPolicy = p }
+}
+
+// WithTokenizer sets the Tokenizer used to estimate plugin output size when
+// a plugin does not report output_token_count. Default is DefaultTokenizer.
+func WithTokenizer(t Tokenizer) Option {
+	return func(r *Runner) { r.tokenizer = t }
+}
+
+// WithPluginDirs adds plugin directories searched before CTX_PLUGIN_PATH,
+// PATH and project-local .ctx/plugins directories, in the given order.
+func WithPluginDirs(dirs ...string) Option {
+	return func(r *Runner) { r.pluginDirs = append(r.pluginDirs, dirs...) }
+}
+
+// WithDuplicatePolicy sets how results are kept when several plugins report
+// the same name. Default is DuplicateFirst.
+func WithDuplicatePolicy(p DuplicatePolicy) Option {
+	return func(r *Runner) { r.duplicatePolicy = p }
+}
+
+// WithSelection restricts which discovered plugins Run executes. Plugins
+// matching any only selector are kept (all are, if only is empty), then
+// those matching any skip selector are dropped. See Select for the syntax.
+func WithSelection(only, skip []string) Option {
+	return func(r *Runner) {
+		r.only = only
+		r.skip = skip
+	}
+}
+
+// WithPluginConfig sets per-plugin environment overrides and timeouts,
+// keyed by plugin name as returned by PluginName.
+func WithPluginConfig(plugins map[string]PluginConfig) Option {
+	return func(r *Runner) { r.pluginConfig = plugins }
+}
+
+// WithPriorities assigns plugin priorities by reported name. Higher values
+// are kept in preference to lower ones when enforcing budgets; unlisted
+// plugins have priority 0.
+func WithPriorities(priority map[string]int) Option {
+	return func(r *Runner) { r.priority = priority }
+}
+
+// WithStrictSchemas rejects plugin output whose data violates the plugin's
+// data_schema or data_schema_url. By default violations are only reported.
+func WithStrictSchemas(strict bool) Option {
+	return func(r *Runner) { r.strictSchemas = strict }
+}
+
+// WithResultHandler sets a function called with each plugin's Outcome as
+// soon as the plugin finishes, before the run completes. Calls are
+// serialized. See NDJSONWriter. Run and Execute fail if DuplicateError, or
+// a budget with a policy other than BudgetNone, is also in effect.
+func WithResultHandler(fn func(Outcome)) Option {
+	return func(r *Runner) { r.resultHandler = fn }
+}
+
+// WithSessionID sets the CTX_SESSION for runs instead of inheriting it from
+// the environment or generating a new one. Empty means unset.
+func WithSessionID(id string) Option {
+	return func(r *Runner) { r.session = id }
+}
+
+// WithResultCache makes the Runner reuse plugin results held in c, and
+// store fresh ones there. See ResultCache.
+func WithResultCache(c *ResultCache) Option {
+	return func(r *Runner) { r.resultCache = c }
+}
+
+// WithProcessLimit makes the Runner share l with other Runners, so that
+// together they run no more plugin processes at once than l allows.
+func WithProcessLimit(l *ProcessLimit) Option {
+	return func(r *Runner) { r.processLimit = l }
+}
+
+// WithLogDir sets the directory holding per-session logs of plugin stderr
+// (see SessionLogPath), such as DefaultLogDir. By default there are no logs.
+func WithLogDir(dir string) Option {
+	return func(r *Runner) { r.logDir = dir }
+}
+
+// WithLogRetention sets how many session logs are kept in the log
+// directory; after each run the oldest beyond that are removed. Default is
+// DefaultLogRetention; zero or less keeps all.
+func WithLogRetention(keep int) Option {
+	return func(r *Runner) { r.logKeep = keep }
+}
+
+// WithStderrInOutput records up to maxBytes of each successful plugin's
+// stderr in Result.Stderr, which Format includes in the metadata. Zero
+// disables it. Failed plugins' stderr is always in their PluginError.
+func WithStderrInOutput(maxBytes int) Option {
+	return func(r *Runner) { r.embedStderr = maxBytes }
+}
@@ -0,0 +1,274 @@
+package ctxrun
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// PluginData defines the expected JSON structure from plugins.
+type PluginData struct {
+	Name    string          `json:"name"`
+	Version string          `json:"version"`
+	Data    json.RawMessage `json:"data"` // Keep data as raw JSON initially
+
+	// RequiresApproval is set instead of Data when the plugin asks for user
+	// approval before doing its work (incubating, see spec section 5).
+	RequiresApproval json.RawMessage `json:"requires_approval,omitempty"`
+	// DataSchemaURL and DataSchema optionally describe the structure of Data.
+	DataSchemaURL string          `json:"data_schema_url,omitempty"`
+	DataSchema    json.RawMessage `json:"data_schema,omitempty"`
+	// Metrics carries the plugin's optional usage metrics.
+	Metrics *Metrics `json:"metrics,omitempty"`
+	// CacheInfo carries the plugin's cacheability hints, if any.
+	CacheInfo *CacheInfo `json:"cache_info,omitempty"`
+	// Source optionally carries the plugin's source code as a txtar archive,
+	// returned when CTX_SHOW_SOURCE is set.
+	Source string `json:"source,omitempty"`
+
+	approved bool   // Produced by a re-run with CTX_APPROVED=true; never cached
+	attempts int    // Executions needed to produce this result, including retries
+	stderr   string // Captured if embedding is enabled, see WithStderrInOutput
+}
+
+// pluginOutcome is the result of running a single plugin, gathered
+// concurrently and merged into a Result in plugin order.
+type pluginOutcome struct {
+	path       string
+	data       PluginData
+	hit        bool // Served from the host-side cache
+	skipped    bool // Mode not supported
+	violations []string
+	warnings   []string
+	perr       *PluginError
+}
+
+// executePlugins runs discovered plugins concurrently and aggregates their
+// JSON output into res. Failures are collected rather than dropped, sorted by
+// plugin path. Results are merged in plugin order once all plugins finish,
+// so the outcome does not depend on scheduling; each is also passed to the
+// result handler, if any, as soon as it is available.
+func (r *Runner) executePlugins(ctx context.Context, pluginPaths []string, pluginEnv []string, res *Result) {
+	var wg sync.WaitGroup
+	outcomes := make([]pluginOutcome, len(pluginPaths))
+
+	// Semaphore to limit concurrent executions
+	semaphore := make(chan struct{}, r.maxParallel)
+
+	for i, pluginPath := range pluginPaths {
+		wg.Add(1)
+		semaphore <- struct{}{} // Acquire semaphore token
+		go func(out *pluginOutcome, pPath string) {
+			defer wg.Done()
+			defer func() { <-semaphore }() // Release semaphore token
+			defer r.report(out, time.Now())
+			out.path = pPath
+
+			env := r.pluginEnvFor(pPath, pluginEnv)
+			entry, warning, perr := r.verifyTrust(pPath)
+			if warning != "" {
+				out.warnings = append(out.warnings, warning)
+			}
+			var caps *Capabilities
+			if perr == nil && (r.queryCapabilities || r.mode != "") {
+				var err error
+				caps, err = r.capabilities(ctx, pPath, env)
+				if err != nil {
+					r.logf("[%s] Warning: Capability query failed: %v", filepath.Base(pPath), err)
+				}
+				if !caps.SupportsMode(r.mode) {
+					r.logf("[%s] Skipping: mode '%s' not supported (supports %v).", filepath.Base(pPath), r.mode, caps.Modes)
+					out.skipped = true
+					return
+				}
+			}
+			if perr == nil {
+				out.data, out.hit, perr = r.cachedRunPlugin(ctx, pPath, env, caps)
+			}
+			if perr == nil {
+				warning, perr = r.checkTrustedVersion(pPath, entry, out.data)
+				if warning != "" {
+					out.warnings = append(out.warnings, warning)
+				}
+			}
+			if perr == nil {
+				out.violations, warning, perr = r.checkSchema(pPath, out.data)
+				if warning != "" {
+					out.warnings = append(out.warnings, warning)
+				}
+			}
+			out.perr = perr
+		}(&outcomes[i], pluginPath)
+	}
+
+	wg.Wait()
+	keys := r.resolveDuplicates(outcomes, res)
+	for i, out := range outcomes {
+		res.Warnings = append(res.Warnings, out.warnings...)
+		switch {
+		case out.skipped:
+			res.Skipped = append(res.Skipped, out.path)
+			continue
+		case out.perr != nil:
+			res.Errors = append(res.Errors, out.perr)
+			continue
+		case keys[i] == "":
+			continue // Lost a name conflict
+		}
+		key := keys[i]
+		res.Plugins[key] = out.data
+		res.Paths[key] = out.path
+		if len(out.violations) > 0 {
+			if res.SchemaViolations == nil {
+				res.SchemaViolations = make(map[string][]string)
+			}
+			res.SchemaViolations[key] = out.violations
+		}
+		if out.hit {
+			res.Cached = append(res.Cached, key)
+		} else if out.data.attempts > 1 {
+			if res.Attempts == nil {
+				res.Attempts = make(map[string]int)
+			}
+			res.Attempts[key] = out.data.attempts
+		}
+		if out.data.stderr != "" {
+			if res.Stderr == nil {
+				res.Stderr = make(map[string]string)
+			}
+			res.Stderr[key] = out.data.stderr
+		}
+	}
+	sort.Strings(res.Cached)
+	sort.Strings(res.Skipped)
+	sort.Strings(res.Warnings)
+	sort.Slice(res.Errors, func(i, j int) bool { return res.Errors[i].Path < res.Errors[j].Path })
+}
+
+// runPlugin executes a single plugin, retrying transient failures and
+// handling the approval flow if the plugin asks for it.
+func (r *Runner) runPlugin(ctx context.Context, pluginPath string, pluginEnv []string) (PluginData, *PluginError) {
+	data, attempts, perr := r.execWithRetry(ctx, pluginPath, pluginEnv)
+	data.attempts = attempts
+	if perr != nil || data.RequiresApproval == nil {
+		return data, perr
+	}
+	return r.runApproved(ctx, pluginPath, pluginEnv, data)
+}
+
+// execPlugin executes a single plugin once and decodes its output.
+func (r *Runner) execPlugin(ctx context.Context, pluginPath string, pluginEnv []string) (PluginData, *PluginError) {
+	execName := filepath.Base(pluginPath)
+	release, err := r.processLimit.acquire(ctx)
+	if err != nil {
+		perr := &PluginError{Path: pluginPath, Kind: ErrorKindExec, ExitCode: -1, Message: err.Error()}
+		if err == context.DeadlineExceeded {
+			perr.Kind, perr.Message = ErrorKindTimeout, "global timeout reached"
+		}
+		return PluginData{}, perr
+	}
+	r.logf("[%s] Running plugin...", execName)
+
+	// Each plugin gets its own deadline, starting now, within any global cap on ctx.
+	pctx := ctx
+	timeout := r.pluginTimeoutFor(pluginPath)
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		pctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	env := pluginEnv
+	if deadline, ok := pctx.Deadline(); ok {
+		env = append(env[:len(env):len(env)], deadlineEnvKey+"="+strconv.FormatInt(deadline.Unix(), 10))
+	}
+
+	var stdout bytes.Buffer
+	stderr := r.newPluginStderr(execName, pluginEnv)
+	cmd := exec.Command(pluginPath)
+	cmd.Env = env
+	cmd.Stdout = &stdout
+	cmd.Stderr = stderr
+	start := time.Now()
+	term, err := runProcess(pctx, cmd, r.killGrace, func() {
+		stderr.Close()
+		release()
+	})
+	perr := &PluginError{
+		Path:     pluginPath,
+		Duration: time.Since(start),
+		ExitCode: -1,
+	}
+	if err != errProcessStuck { // Otherwise stderr may still be written to
+		perr.Stderr = truncate(stderr.String(), maxStderrLen)
+		if cmd.ProcessState != nil {
+			perr.ExitCode = cmd.ProcessState.ExitCode()
+		}
+	}
+
+	if err != nil || term != notTerminated {
+		perr.Kind = ErrorKindExec
+		if err != nil {
+			perr.Message = err.Error()
+		}
+		switch {
+		case pctx.Err() == context.DeadlineExceeded && ctx.Err() == nil:
+			perr.Kind = ErrorKindTimeout
+			perr.Message = fmt.Sprintf("timed out after %s", timeout)
+		case ctx.Err() == context.DeadlineExceeded:
+			perr.Kind = ErrorKindTimeout
+			perr.Message = "global timeout reached"
+		case ctx.Err() != nil:
+			perr.Message = ctx.Err().Error()
+		}
+		switch term {
+		case terminated:
+			perr.Message += "; stopped with SIGTERM"
+		case killed:
+			perr.Message += fmt.Sprintf("; killed with SIGKILL after %s grace period", r.killGrace)
+			if err == errProcessStuck {
+				perr.Message += " (" + err.Error() + ")"
+			}
+		}
+		r.logf("[%s] Error: failed to execute plugin '%s': %s. Stderr: %s", execName, execName, perr.Message, perr.Stderr)
+		return PluginData{}, perr
+	}
+
+	var data PluginData
+	if err := json.Unmarshal(stdout.Bytes(), &data); err != nil {
+		const maxLogLen = 200
+		perr.Kind = ErrorKindParse
+		perr.Message = fmt.Sprintf("failed parsing JSON output: %v", err)
+		r.logf("[%s] Error: %s. Output (truncated): %s", execName, perr.Message, truncate(stdout.String(), maxLogLen))
+		return PluginData{}, perr
+	}
+
+	if data.Data == nil && data.RequiresApproval != nil && data.Name != "" && data.Version != "" {
+		r.logf("[%s] Plugin requires approval.", execName)
+		return data, nil
+	}
+	if data.Name == "" || data.Version == "" || data.Data == nil {
+		perr.Kind = ErrorKindValidation
+		perr.Message = "plugin output missing required field ('name', 'version', or 'data')"
+		r.logf("[%s] Error: Plugin output missing required field ('name', 'version', or 'data'). Skipping.", execName)
+		return PluginData{}, perr
+	}
+	if data.Name == MetaKey {
+		perr.Kind = ErrorKindValidation
+		perr.Message = fmt.Sprintf("plugin name %q is reserved", MetaKey)
+		r.logf("[%s] Error: Plugin name '%s' is reserved. Skipping.", execName, MetaKey)
+		return PluginData{}, perr
+	}
+
+	if r.embedStderr > 0 {
+		data.stderr = truncate(stderr.String(), r.embedStderr)
+	}
+	r.logf("[%s] Success (Reported Version: %s).", data.Name, data.Version)
+	return data, nil
+}
@@ -0,0 +1,106 @@
+package ctxrun
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// DefaultKillGrace is how long a plugin gets to exit after SIGTERM before
+// it is killed.
+const DefaultKillGrace = 5 * time.Second
+
+// errProcessStuck reports a process that did not exit even after SIGKILL,
+// typically because a descendant still holds its output pipes.
+var errProcessStuck = errors.New("process did not exit after SIGKILL")
+
+// ProcessLimit caps how many plugin processes run at once across all
+// Runners sharing it, such as those serving concurrent requests in a
+// long-running process. It applies in addition to each Runner's own
+// WithMaxParallel limit. A process that does not exit after SIGKILL keeps
+// its slot until it does.
+type ProcessLimit struct {
+	slots chan struct{}
+}
+
+// NewProcessLimit returns a ProcessLimit allowing n processes at once.
+func NewProcessLimit(n int) *ProcessLimit {
+	if n < 1 {
+		n = 1
+	}
+	return &ProcessLimit{slots: make(chan struct{}, n)}
+}
+
+// acquire waits for a free slot and returns the function releasing it. A
+// nil ProcessLimit never waits.
+func (l *ProcessLimit) acquire(ctx context.Context) (release func(), err error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	var once sync.Once
+	return func() { once.Do(func() { <-l.slots }) }, nil
+}
+
+// termination records how runProcess had to stop a process.
+type termination int
+
+const (
+	notTerminated termination = iota // Process exited on its own
+	terminated                       // Process exited after SIGTERM
+	killed                           // Process needed SIGKILL
+)
+
+// runProcess starts cmd and waits for it to exit. When ctx is done first the
+// process is sent SIGTERM and, if it has not exited after grace, SIGKILL.
+// Output buffers attached to cmd must not be read if errProcessStuck is
+// returned. exited is called once cmd's output is no longer written to:
+// before runProcess returns, or for a stuck process whenever it finally
+// exits, so that it can release what the output was written to.
+func runProcess(ctx context.Context, cmd *exec.Cmd, grace time.Duration, exited func()) (termination, error) {
+	if err := ctx.Err(); err != nil {
+		exited()
+		return notTerminated, err
+	}
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		exited()
+		return notTerminated, err
+	}
+	done := make(chan error, 1)
+	go func() {
+		err := cmd.Wait()
+		exited()
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		return notTerminated, err
+	case <-ctx.Done():
+	}
+
+	terminate(cmd.Process, false)
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return terminated, err
+	case <-timer.C:
+	}
+
+	terminate(cmd.Process, true)
+	timer.Reset(grace)
+	select {
+	case err := <-done:
+		return killed, err
+	case <-timer.C:
+		return killed, errProcessStuck
+	}
+}
@@ -0,0 +1,17 @@
+//go:build windows || plan9
+
+package ctxrun
+
+import (
+	"os"
+	"os/exec"
+)
+
+// setProcessGroup is a no-op where process groups are not supported.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// terminate kills the plugin; there is no graceful SIGTERM step on this
+// platform.
+func terminate(p *os.Process, kill bool) {
+	p.Kill()
+}
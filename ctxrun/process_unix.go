@@ -0,0 +1,27 @@
+//go:build !windows && !plan9
+
+package ctxrun
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup starts the plugin in its own process group so that
+// signals also reach any children it spawned.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// terminate sends SIGTERM, or SIGKILL if kill is set, to the plugin's
+// process group.
+func terminate(p *os.Process, kill bool) {
+	sig := syscall.SIGTERM
+	if kill {
+		sig = syscall.SIGKILL
+	}
+	if err := syscall.Kill(-p.Pid, sig); err != nil {
+		p.Signal(sig)
+	}
+}
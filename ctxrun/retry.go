@@ -0,0 +1,108 @@
+package ctxrun
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultRetryBackoff is the delay before the first retry; it doubles on each
+// further attempt up to maxRetryBackoff.
+const DefaultRetryBackoff = 500 * time.Millisecond
+
+// maxRetryBackoff caps the delay between attempts.
+const maxRetryBackoff = 30 * time.Second
+
+// DefaultRetryExitCodes are the exit statuses treated as transient failures:
+// 75 is EX_TEMPFAIL from sysexits.h.
+var DefaultRetryExitCodes = []int{75}
+
+var (
+	jitterMu   sync.Mutex
+	jitterRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// execWithRetry runs a plugin via execPlugin, retrying retryable failures up
+// to pluginRetries times with exponential backoff and jitter. It returns the
+// number of attempts made alongside the final outcome.
+func (r *Runner) execWithRetry(ctx context.Context, pluginPath string, pluginEnv []string) (PluginData, int, *PluginError) {
+	for attempt := 1; ; attempt++ {
+		data, perr := r.execPlugin(ctx, pluginPath, pluginEnv)
+		if perr == nil {
+			return data, attempt, nil
+		}
+		perr.Attempts = attempt
+		if attempt > r.pluginRetries || ctx.Err() != nil || !r.retryable(perr) {
+			return data, attempt, perr
+		}
+		delay := r.backoff(attempt)
+		r.logf("[%s] Retryable failure (%s: %s); retrying in %s (attempt %d of %d).", filepath.Base(pluginPath), perr.Kind, perr.Message, delay.Round(time.Millisecond), attempt+1, r.pluginRetries+1)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return data, attempt, perr
+		case <-timer.C:
+		}
+	}
+}
+
+// retryable reports whether a failed execution may succeed if repeated:
+// timeouts, exits with a status in the runner's retry set, and failures
+// whose stderr carries a JSON object with "retryable": true.
+func (r *Runner) retryable(perr *PluginError) bool {
+	switch perr.Kind {
+	case ErrorKindTimeout:
+		return true
+	case ErrorKindExec:
+		for _, code := range r.retryExitCodes {
+			if perr.ExitCode == code {
+				return true
+			}
+		}
+		return stderrRetryable(perr.Stderr)
+	}
+	return false
+}
+
+// stderrRetryable looks for a JSON object with "retryable": true, either as
+// the whole of stderr or on any single line of it.
+func stderrRetryable(stderr string) bool {
+	var v struct {
+		Retryable bool `json:"retryable"`
+	}
+	candidates := append([]string{stderr}, strings.Split(stderr, "\n")...)
+	for _, c := range candidates {
+		c = strings.TrimSpace(c)
+		if !strings.HasPrefix(c, "{") {
+			continue
+		}
+		if json.Unmarshal([]byte(c), &v) == nil && v.Retryable {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the delay before the attempt following attempt: the base
+// delay doubled per prior attempt, capped, with jitter drawn from its upper
+// half.
+func (r *Runner) backoff(attempt int) time.Duration {
+	d := r.retryBackoff
+	for i := 1; i < attempt && d < maxRetryBackoff; i++ {
+		d *= 2
+	}
+	if d > maxRetryBackoff {
+		d = maxRetryBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	jitterMu.Lock()
+	defer jitterMu.Unlock()
+	return d/2 + time.Duration(jitterRand.Int63n(int64(d/2)+1))
+}
@@ -0,0 +1,152 @@
+package ctxrun
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		name  string
+		codes []int // nil means DefaultRetryExitCodes
+		perr  PluginError
+		want  bool
+	}{
+		{"timeout", nil, PluginError{Kind: ErrorKindTimeout, ExitCode: -1}, true},
+		{"default exit code", nil, PluginError{Kind: ErrorKindExec, ExitCode: 75}, true},
+		{"other exit code", nil, PluginError{Kind: ErrorKindExec, ExitCode: 1}, false},
+		{"configured exit code", []int{3, 4}, PluginError{Kind: ErrorKindExec, ExitCode: 4}, true},
+		{"default replaced", []int{3}, PluginError{Kind: ErrorKindExec, ExitCode: 75}, false},
+		{"no exit codes", []int{}, PluginError{Kind: ErrorKindExec, ExitCode: 75}, false},
+		{"stderr hint", []int{}, PluginError{Kind: ErrorKindExec, ExitCode: 1, Stderr: `{"retryable": true}`}, true},
+		{"parse error with hint", nil, PluginError{Kind: ErrorKindParse, ExitCode: 0, Stderr: `{"retryable": true}`}, false},
+		{"validation error", nil, PluginError{Kind: ErrorKindValidation, ExitCode: 75}, false},
+		{"denied", nil, PluginError{Kind: ErrorKindDenied, ExitCode: 75}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := []Option{}
+			if tt.codes != nil {
+				opts = append(opts, WithRetryExitCodes(tt.codes))
+			}
+			if got := New(opts...).retryable(&tt.perr); got != tt.want {
+				t.Errorf("retryable(%+v) = %v, want %v", tt.perr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStderrRetryable(t *testing.T) {
+	tests := []struct {
+		stderr string
+		want   bool
+	}{
+		{"", false},
+		{`{"retryable": true}`, true},
+		{`  {"retryable":true}  ` + "\n", true},
+		{`{"retryable": false}`, false},
+		{`{"retryable": "true"}`, false},
+		{`{"error": "rate limited"}`, false},
+		{`retryable: true`, false},
+		{`not json {"retryable": true}`, false},
+		{"warming up\n" + `{"retryable": true, "reason": "rate limited"}` + "\nbye\n", true},
+		{"warming up\r\n" + `{"retryable": true}` + "\r\n", true},
+		{"{\n  \"retryable\": true\n}\n", true},
+		{"{\n  \"retryable\": true\n}\nand more\n", false},
+		{`{"retryable": true`, false},
+		{`[{"retryable": true}]`, false},
+	}
+	for _, tt := range tests {
+		if got := stderrRetryable(tt.stderr); got != tt.want {
+			t.Errorf("stderrRetryable(%q) = %v, want %v", tt.stderr, got, tt.want)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		base    time.Duration
+		attempt int
+		max     time.Duration // Before jitter; the delay is within [max/2, max]
+	}{
+		{100 * time.Millisecond, 1, 100 * time.Millisecond},
+		{100 * time.Millisecond, 2, 200 * time.Millisecond},
+		{100 * time.Millisecond, 4, 800 * time.Millisecond},
+		{10 * time.Second, 2, 20 * time.Second},
+		{10 * time.Second, 3, maxRetryBackoff},
+		{time.Second, 1000, maxRetryBackoff},
+		{time.Minute, 1, maxRetryBackoff},
+		{0, 3, 0},
+	}
+	for _, tt := range tests {
+		r := New(WithRetryBackoff(tt.base))
+		for i := 0; i < 200; i++ {
+			if d := r.backoff(tt.attempt); d < tt.max/2 || d > tt.max {
+				t.Fatalf("backoff(%d) with base %s = %s, want within [%s, %s]", tt.attempt, tt.base, d, tt.max/2, tt.max)
+			}
+		}
+	}
+}
+
+func TestRetryAttempts(t *testing.T) {
+	// Each plugin counts its executions in a file and fails until the count
+	// reaches succeedAt; zero means it always fails.
+	plugin := func(dir, name string, succeedAt, exitCode int, stderr string) string {
+		count := filepath.Join(dir, name+".count")
+		return writePlugin(t, dir, "ctx-"+name, fmt.Sprintf(`n=0
+[ -f %[1]s ] && read n < %[1]s
+n=$((n+1))
+echo $n > %[1]s
+if [ %[2]d -eq 0 ] || [ $n -lt %[2]d ]; then
+	echo '%[4]s' >&2
+	exit %[3]d
+fi
+echo '{"name":"%[5]s","version":"1","data":'$n'}'
+`, count, succeedAt, exitCode, stderr, name))
+	}
+	dir := t.TempDir()
+	tests := []struct {
+		name         string
+		path         string
+		wantAttempts int  // Recorded attempts; 0 means not recorded
+		wantErr      bool // Whether the plugin ends in Errors
+	}{
+		{"first try", plugin(dir, "ok", 1, 1, ""), 0, false},
+		{"exit code then success", plugin(dir, "flaky", 3, 75, "busy"), 3, false},
+		{"stderr hint then success", plugin(dir, "hinted", 2, 1, `{"retryable": true}`), 2, false},
+		{"retries exhausted", plugin(dir, "down", 0, 75, "busy"), 3, true},
+		{"not retryable", plugin(dir, "broken", 0, 1, "bad input"), 1, true},
+	}
+	r := New(WithPluginRetries(2), WithRetryBackoff(time.Millisecond))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := r.Execute(context.Background(), []string{tt.path})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantErr {
+				if len(res.Errors) != 1 {
+					t.Fatalf("errors = %v, want one", res.Errors)
+				}
+				if got := res.Errors[0].Attempts; got != tt.wantAttempts {
+					t.Errorf("error attempts = %d, want %d", got, tt.wantAttempts)
+				}
+				return
+			}
+			if len(res.Errors) != 0 || len(res.Plugins) != 1 {
+				t.Fatalf("plugins %v, errors %v; want one plugin", res.Plugins, res.Errors)
+			}
+			for name, p := range res.Plugins {
+				if got := res.Attempts[name]; got != tt.wantAttempts {
+					t.Errorf("attempts = %d, want %d", got, tt.wantAttempts)
+				}
+				if want := fmt.Sprint(tt.wantAttempts); tt.wantAttempts > 0 && string(p.Data) != want {
+					t.Errorf("data = %s, want %s", p.Data, want)
+				}
+			}
+		})
+	}
+}
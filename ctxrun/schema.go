@@ -0,0 +1,106 @@
+package ctxrun
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SchemaCachePath returns where a schema referenced by data_schema_url is
+// looked up: <cache dir>/schemas/<hex SHA256 of the URL>.json. ctx never
+// fetches schemas over the network; populate this file to enable checks.
+func (r *Runner) SchemaCachePath(schemaURL string) string {
+	base := r.resolveCacheDir()
+	if base == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(schemaURL))
+	return filepath.Join(base, "schemas", hex.EncodeToString(sum[:])+".json")
+}
+
+// pluginSchema returns the schema for a plugin's data: the embedded
+// data_schema, a file:// data_schema_url within the plugin's own directory,
+// or a locally cached copy of an http(s) data_schema_url. It returns nil, nil
+// if the plugin declares none.
+func (r *Runner) pluginSchema(pluginPath string, p PluginData) (json.RawMessage, error) {
+	if len(p.DataSchema) > 0 {
+		return p.DataSchema, nil
+	}
+	if p.DataSchemaURL == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(p.DataSchemaURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid data_schema_url: %w", err)
+	}
+	var path string
+	switch u.Scheme {
+	case "http", "https":
+		path = r.SchemaCachePath(p.DataSchemaURL)
+	case "file":
+		if path, err = pluginLocalFile(pluginPath, u.Path); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported data_schema_url scheme %q: want http, https or file", u.Scheme)
+	}
+	if path == "" {
+		return nil, errors.New("no cache directory for data_schema_url")
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("schema %s is not cached at %s", p.DataSchemaURL, path)
+	}
+	return b, err
+}
+
+// pluginLocalFile returns the real path of name if it lies within the
+// directory of the plugin at pluginPath, after resolving symlinks, so that
+// plugin output cannot make ctx read arbitrary local files.
+func pluginLocalFile(pluginPath, name string) (string, error) {
+	dir, err := filepath.EvalSymlinks(filepath.Dir(pluginPath))
+	if err != nil {
+		return "", err
+	}
+	path, err := filepath.EvalSymlinks(name)
+	if err != nil {
+		return "", err
+	}
+	if rel, err := filepath.Rel(dir, path); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || filepath.IsAbs(rel) {
+		return "", fmt.Errorf("data_schema_url %s is outside the plugin directory %s", name, dir)
+	}
+	return path, nil
+}
+
+// checkSchema validates a plugin's data against its declared schema. Under
+// strict validation violations become a PluginError; otherwise they are
+// returned for reporting. Schemas that cannot be loaded produce a warning.
+func (r *Runner) checkSchema(pluginPath string, p PluginData) (violations []string, warning string, perr *PluginError) {
+	execName := filepath.Base(pluginPath)
+	schema, err := r.pluginSchema(pluginPath, p)
+	if err == nil && schema != nil {
+		violations, err = ValidateSchema(schema, p.Data)
+	}
+	if err != nil {
+		r.logf("[%s] Warning: Could not validate data against schema: %v", execName, err)
+		return nil, fmt.Sprintf("schema check skipped for %s: %v", p.Name, err), nil
+	}
+	if len(violations) == 0 {
+		return nil, "", nil
+	}
+	r.logf("[%s] Data violates its schema: %s", execName, strings.Join(violations, "; "))
+	if r.strictSchemas {
+		return nil, "", &PluginError{
+			Path:    pluginPath,
+			Kind:    ErrorKindSchema,
+			Message: "data violates schema: " + strings.Join(violations, "; "),
+		}
+	}
+	return violations, "", nil
+}
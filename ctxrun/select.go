@@ -0,0 +1,117 @@
+package ctxrun
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// tagSelectorPrefix marks a selector that matches capability tags rather
+// than plugin names.
+const tagSelectorPrefix = "tag:"
+
+// PluginName returns the name a plugin is selected by: its executable name
+// without the "ctx-" prefix (and ".exe" suffix), e.g. "git" for ctx-git.
+func PluginName(pluginPath string) string {
+	name := filepath.Base(pluginPath)
+	name = strings.TrimSuffix(name, ".exe")
+	return strings.TrimPrefix(name, "ctx-")
+}
+
+// Select filters plugin paths by the runner's --only and --skip selectors.
+// A selector is a plugin name or glob ("git", "ctx-go*"), a path or path
+// glob (relative to the working directory unless absolute), or "tag:<glob>"
+// matching a tag in the plugin's capability document. With no --only
+// selectors every plugin is selected. Select also returns warnings for
+// --only selectors that matched no plugin.
+func (r *Runner) Select(ctx context.Context, pluginPaths []string) ([]string, []string, error) {
+	if len(r.only) == 0 && len(r.skip) == 0 {
+		return pluginPaths, nil, nil
+	}
+	for _, sel := range append(r.only[:len(r.only):len(r.only)], r.skip...) {
+		if _, err := path.Match(selectorPattern(sel), ""); err != nil {
+			return nil, nil, fmt.Errorf("invalid plugin selector %q: %w", sel, err)
+		}
+	}
+
+	tags := make(map[string][]string) // Capability tags, queried at most once per plugin
+	tagsFor := func(pluginPath string) []string {
+		if t, ok := tags[pluginPath]; ok {
+			return t
+		}
+		caps, err := r.Capabilities(ctx, pluginPath)
+		if err != nil {
+			r.logf("[%s] Warning: Cannot read tags: %v", filepath.Base(pluginPath), err)
+		}
+		var t []string
+		if caps != nil {
+			t = caps.Tags
+		}
+		tags[pluginPath] = t
+		return t
+	}
+
+	matched := make(map[string]bool, len(r.only))
+	var selected []string
+	for _, p := range pluginPaths {
+		keep := len(r.only) == 0
+		for _, sel := range r.only {
+			if r.selects(sel, p, tagsFor) {
+				keep = true
+				matched[sel] = true
+			}
+		}
+		for _, sel := range r.skip {
+			if keep && r.selects(sel, p, tagsFor) {
+				r.logf("[%s] Skipping: excluded by '%s'.", filepath.Base(p), sel)
+				keep = false
+			}
+		}
+		if keep {
+			selected = append(selected, p)
+		}
+	}
+
+	var warnings []string
+	for _, sel := range r.only {
+		if !matched[sel] {
+			warnings = append(warnings, fmt.Sprintf("no plugin matches %q", sel))
+		}
+	}
+	r.logf("Selected %d of %d plugin(s).", len(selected), len(pluginPaths))
+	return selected, warnings, nil
+}
+
+// selects reports whether a single selector matches a plugin.
+func (r *Runner) selects(sel, pluginPath string, tagsFor func(string) []string) bool {
+	pattern := selectorPattern(sel)
+	if strings.HasPrefix(sel, tagSelectorPrefix) {
+		for _, tag := range tagsFor(pluginPath) {
+			if ok, _ := path.Match(pattern, tag); ok {
+				return true
+			}
+		}
+		return false
+	}
+	if strings.ContainsRune(sel, filepath.Separator) {
+		// Discovered paths are absolute; so must the pattern be.
+		if abs, err := filepath.Abs(sel); err == nil {
+			sel = abs
+		}
+		ok, _ := filepath.Match(sel, pluginPath)
+		return ok
+	}
+	ok, _ := path.Match(pattern, PluginName(pluginPath))
+	return ok
+}
+
+// selectorPattern returns the glob a selector matches with: the tag pattern
+// for tag selectors, otherwise the name pattern without any "ctx-" prefix.
+func selectorPattern(sel string) string {
+	if strings.HasPrefix(sel, tagSelectorPrefix) {
+		return strings.TrimPrefix(sel, tagSelectorPrefix)
+	}
+	return strings.TrimPrefix(sel, "ctx-")
+}
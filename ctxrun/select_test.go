@@ -0,0 +1,85 @@
+package ctxrun
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSelect(t *testing.T) {
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "bin")
+	if err := os.Mkdir(bin, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	spec := func(tags string) string {
+		return `[ "$1" = --ctx-spec ] && echo '{"ctx_spec":"0.1.0","name":"x","version":"1","tags":` + tags + `}'` + "\n"
+	}
+	plugins := []string{
+		writePlugin(t, bin, "ctx-git", spec(`["vcs"]`)),
+		writePlugin(t, bin, "ctx-go-mod", spec(`["lang-go","deps"]`)),
+		writePlugin(t, bin, "ctx-gofmt", spec(`["lang-go"]`)),
+		writePlugin(t, bin, "ctx-env", "exit 1\n"), // No capability document
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	tests := []struct {
+		name       string
+		only, skip []string
+		want       []string // Base names, in discovery order
+		warnings   []string
+	}{
+		{name: "no selectors", want: []string{"ctx-git", "ctx-go-mod", "ctx-gofmt", "ctx-env"}},
+		{name: "name", only: []string{"git"}, want: []string{"ctx-git"}},
+		{name: "name with prefix", only: []string{"ctx-gofmt"}, want: []string{"ctx-gofmt"}},
+		{name: "glob", only: []string{"go*"}, want: []string{"ctx-go-mod", "ctx-gofmt"}},
+		{name: "prefixed glob", only: []string{"ctx-go-*"}, want: []string{"ctx-go-mod"}},
+		{name: "several", only: []string{"env", "git"}, want: []string{"ctx-git", "ctx-env"}},
+		{name: "absolute path", only: []string{plugins[0]}, want: []string{"ctx-git"}},
+		{name: "relative path", only: []string{"./bin/ctx-gofmt"}, want: []string{"ctx-gofmt"}},
+		{name: "relative path glob", only: []string{"bin/ctx-go*"}, want: []string{"ctx-go-mod", "ctx-gofmt"}},
+		{name: "uncleaned path", only: []string{"bin/../bin/ctx-env"}, want: []string{"ctx-env"}},
+		{name: "tag", only: []string{"tag:lang-go"}, want: []string{"ctx-go-mod", "ctx-gofmt"}},
+		{name: "tag glob", only: []string{"tag:lang-*"}, want: []string{"ctx-go-mod", "ctx-gofmt"}},
+		{name: "skip only", skip: []string{"go*"}, want: []string{"ctx-git", "ctx-env"}},
+		{name: "skip overrides only", only: []string{"tag:lang-go", "git"}, skip: []string{"gofmt"}, want: []string{"ctx-git", "ctx-go-mod"}},
+		{name: "skip by tag", only: []string{"go*"}, skip: []string{"tag:deps"}, want: []string{"ctx-gofmt"}},
+		{name: "skip by relative path", skip: []string{"./bin/ctx-e*"}, want: []string{"ctx-git", "ctx-go-mod", "ctx-gofmt"}},
+		{name: "skipped selector still matched", only: []string{"git"}, skip: []string{"git"}},
+		{name: "unmatched", only: []string{"git", "nope", "tag:none", "./ctx-git"}, want: []string{"ctx-git"},
+			warnings: []string{`no plugin matches "nope"`, `no plugin matches "tag:none"`, `no plugin matches "./ctx-git"`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := New(WithSelection(tt.only, tt.skip), WithNoCache(true))
+			selected, warnings, err := r.Select(context.Background(), plugins)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, p := range selected {
+				got = append(got, filepath.Base(p))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("selected %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(warnings, tt.warnings) {
+				t.Errorf("warnings %q, want %q", warnings, tt.warnings)
+			}
+		})
+	}
+
+	if _, _, err := New(WithSelection([]string{"[git"}, nil)).Select(context.Background(), plugins); err == nil {
+		t.Error("Select with a malformed glob succeeded, want error")
+	}
+}
@@ -0,0 +1,47 @@
+package ctxrun
+
+import (
+	"os"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+)
+
+// newSessionID returns a new monotonic ULID. IDs generated within the same
+// millisecond by this process still sort in creation order.
+func newSessionID() ulid.ULID {
+	return ulid.Make() // Safe for concurrent use; monotonic within a process
+}
+
+// sessionID retrieves the session ID from the environment or generates a new
+// ULID, along with the session start time encoded in it.
+//
+// An inherited CTX_SESSION is always honored so that nested ctx invocations
+// share one session, unless WithSessionID set one explicitly. If it is not
+// a valid ULID it is passed through as an opaque string and the returned
+// start time is zero.
+func (r *Runner) sessionID() (string, time.Time) {
+	inherited := r.session
+	if inherited == "" {
+		inherited = os.Getenv(sessionEnvKey)
+	}
+	if inherited != "" {
+		start, ok := SessionStart(inherited)
+		if !ok {
+			r.logf("Warning: Inherited %s '%s' is not a valid ULID; session start time unknown.", sessionEnvKey, inherited)
+		}
+		return inherited, start
+	}
+	id := newSessionID()
+	return id.String(), ulid.Time(id.Time())
+}
+
+// SessionStart reports the creation time encoded in a ULID session ID.
+// It returns false if sessionID is not a valid ULID.
+func SessionStart(sessionID string) (time.Time, bool) {
+	id, err := ulid.ParseStrict(sessionID)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return ulid.Time(id.Time()), true
+}
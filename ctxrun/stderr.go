@@ -0,0 +1,167 @@
+package ctxrun
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultLogRetention is the default number of session logs kept in the
+// log directory.
+const DefaultLogRetention = 100
+
+// DefaultLogDir returns $XDG_STATE_HOME/ctx/logs (by default
+// ~/.local/state/ctx/logs), or "" if no home directory can be determined.
+func DefaultLogDir() string {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, "ctx", "logs")
+}
+
+// SessionLogPath returns the file in dir that the stderr of plugins run in
+// the given session is appended to.
+func SessionLogPath(dir, sessionID string) string {
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '.' {
+			return r
+		}
+		return '_'
+	}, sessionID) // Inherited session IDs are arbitrary strings
+	return filepath.Join(dir, name+".log")
+}
+
+// pruneLogs removes the oldest session logs in the log directory beyond the
+// retention limit. The current session's log is always kept.
+func (r *Runner) pruneLogs(current string) {
+	if r.logKeep <= 0 {
+		return
+	}
+	entries, err := os.ReadDir(r.logDir)
+	if err != nil {
+		return
+	}
+	type logFile struct {
+		path    string
+		modTime time.Time
+	}
+	var logs []logFile
+	for _, e := range entries {
+		if !e.Type().IsRegular() || !strings.HasSuffix(e.Name(), ".log") {
+			continue
+		}
+		if info, err := e.Info(); err == nil {
+			logs = append(logs, logFile{filepath.Join(r.logDir, e.Name()), info.ModTime()})
+		}
+	}
+	if len(logs) <= r.logKeep {
+		return
+	}
+	sort.Slice(logs, func(i, j int) bool { return logs[i].modTime.After(logs[j].modTime) })
+	for _, l := range logs[r.logKeep:] {
+		if l.path == current {
+			continue
+		}
+		if err := os.Remove(l.path); err != nil {
+			r.logf("Warning: Could not remove old session log: %v", err)
+		}
+	}
+}
+
+// pluginStderr receives a plugin's stderr. It keeps all of it for error
+// reports and metadata, appends each line to the session log, and passes
+// lines to the Runner's logger as they arrive, prefixed with the plugin.
+type pluginStderr struct {
+	r       *Runner
+	name    string // Executable name
+	logPath string // Session log; empty disables it
+	log     *os.File
+	buf     bytes.Buffer
+	partial []byte // Incomplete last line
+}
+
+func (r *Runner) newPluginStderr(execName string, pluginEnv []string) *pluginStderr {
+	w := &pluginStderr{r: r, name: execName}
+	if session := envValue(pluginEnv, sessionEnvKey); r.logDir != "" && session != "" {
+		w.logPath = SessionLogPath(r.logDir, session)
+	}
+	return w
+}
+
+func (w *pluginStderr) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		w.line(strings.TrimSuffix(string(w.partial[:i]), "\r"))
+		w.partial = w.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// Close emits any incomplete last line and closes the session log.
+func (w *pluginStderr) Close() error {
+	if len(w.partial) > 0 {
+		w.line(string(w.partial))
+		w.partial = nil
+	}
+	if w.log == nil {
+		return nil
+	}
+	return w.log.Close()
+}
+
+func (w *pluginStderr) String() string {
+	return w.buf.String()
+}
+
+func (w *pluginStderr) line(s string) {
+	w.r.logf("[%s] %s", w.name, s)
+	if w.logPath == "" {
+		return
+	}
+	if w.log == nil {
+		if err := os.MkdirAll(filepath.Dir(w.logPath), 0o700); err != nil {
+			w.r.logf("[%s] Warning: Could not create log directory: %v", w.name, err)
+			w.logPath = ""
+			return
+		}
+		f, err := os.OpenFile(w.logPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+		if err != nil {
+			w.r.logf("[%s] Warning: Could not open session log: %v", w.name, err)
+			w.logPath = ""
+			return
+		}
+		w.log = f
+	}
+	// One write per line; O_APPEND keeps lines from concurrent plugins whole.
+	fmt.Fprintf(w.log, "%s [%s] %s\n", time.Now().UTC().Format(time.RFC3339Nano), w.name, s)
+}
+
+// envValue returns the value of key in env, or "".
+func envValue(env []string, key string) string {
+	for _, kv := range env {
+		if k, v, ok := strings.Cut(kv, "="); ok && k == key {
+			return v
+		}
+	}
+	return ""
+}
+
+// isFile reports whether path names an existing regular file.
+func isFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular()
+}
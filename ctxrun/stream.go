@@ -0,0 +1,214 @@
+package ctxrun
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Outcome is a single plugin's result, passed to the handler set with
+// WithResultHandler as soon as the plugin finishes. It precedes duplicate
+// resolution, which needs every plugin's result: under DuplicateFirst or
+// DuplicateNamespace an outcome may be discarded or stored under another
+// key, as recorded in Result.Paths. Policies that would withdraw or rewrite
+// data already handed out are rejected; see checkStreamable.
+type Outcome struct {
+	Path     string
+	Data     PluginData // Valid if Err is nil and Skipped is false
+	Cached   bool       // Served from the host-side cache
+	Skipped  bool       // Mode not supported
+	Err      *PluginError
+	Duration time.Duration
+}
+
+// checkStreamable reports an error if the runner's duplicate or budget
+// policy could withdraw or change results after they are passed to the
+// result handler.
+func (r *Runner) checkStreamable() error {
+	if r.duplicatePolicy == DuplicateError {
+		return fmt.Errorf("streaming results cannot be combined with duplicate policy %q", r.duplicatePolicy)
+	}
+	if (r.outputTokenBudget > 0 || r.costBudgetCents > 0) && r.budgetPolicy != BudgetNone {
+		return fmt.Errorf("streaming results cannot be combined with budget policy %q", r.budgetPolicy)
+	}
+	return nil
+}
+
+// report passes a finished plugin's outcome to the result handler, if any.
+func (r *Runner) report(out *pluginOutcome, start time.Time) {
+	if r.resultHandler == nil {
+		return
+	}
+	o := Outcome{Path: out.path, Data: out.data, Cached: out.hit, Skipped: out.skipped, Err: out.perr, Duration: time.Since(start)}
+	r.handlerMu.Lock()
+	defer r.handlerMu.Unlock()
+	r.resultHandler(o)
+}
+
+// NDJSON record types, in the "type" field of each line.
+const (
+	recordPlugin  = "plugin"
+	recordError   = "error"
+	recordSkipped = "skipped"
+	recordSummary = "summary"
+	recordDiff    = "diff"    // A Watch change to a plugin's data
+	recordRemoved = "removed" // A Watch change removing a plugin
+)
+
+// pluginRecord is an NDJSON line for a plugin that produced data.
+type pluginRecord struct {
+	Type       string          `json:"type"`
+	Name       string          `json:"name"`
+	Version    string          `json:"version"`
+	Path       string          `json:"path,omitempty"`
+	Cached     bool            `json:"cached,omitempty"`
+	Attempts   int             `json:"attempts,omitempty"` // Set if the plugin was retried
+	DurationMS int64           `json:"duration_ms,omitempty"`
+	Metrics    *Metrics        `json:"metrics,omitempty"`
+	Stderr     string          `json:"stderr,omitempty"` // Set with WithStderrInOutput
+	Data       json.RawMessage `json:"data"`
+}
+
+// errorLine is an NDJSON line for a failed plugin.
+type errorLine struct {
+	Type string `json:"type"`
+	errorRecord
+}
+
+// skippedLine is an NDJSON line for a plugin not supporting the requested mode.
+type skippedLine struct {
+	Type string `json:"type"`
+	Path string `json:"path"`
+}
+
+// diffLine is an NDJSON line for a plugin whose result changed.
+type diffLine struct {
+	Type    string    `json:"type"`
+	Name    string    `json:"name"`
+	Version string    `json:"version"`
+	Patch   []PatchOp `json:"patch"`
+}
+
+// removedLine is an NDJSON line for a plugin that no longer has a result.
+type removedLine struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+}
+
+// summaryLine is the final NDJSON line. Its metadata reflects the finished
+// run, after duplicate resolution and budget enforcement; Results lists the
+// plugins in the final result, each with the path of its plugin line.
+type summaryLine struct {
+	Type string `json:"type"`
+	outputMeta
+	Results    map[string]string `json:"results"`
+	DurationMS int64             `json:"duration_ms"`
+}
+
+// NDJSONWriter writes a run as newline-delimited JSON: a line per plugin as
+// it finishes, then a summary line with the session, timing and the
+// metadata otherwise found under MetaKey. Its Outcome method is a result
+// handler (see WithResultHandler), so slow plugins do not hold back others.
+type NDJSONWriter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	err error // First write error
+}
+
+// NewNDJSONWriter returns an NDJSONWriter writing to w.
+func NewNDJSONWriter(w io.Writer) *NDJSONWriter {
+	return &NDJSONWriter{enc: json.NewEncoder(w)}
+}
+
+// Outcome writes the line for a finished plugin.
+func (s *NDJSONWriter) Outcome(o Outcome) {
+	switch {
+	case o.Skipped:
+		s.write(skippedLine{Type: recordSkipped, Path: o.Path})
+	case o.Err != nil:
+		s.write(errorLine{Type: recordError, errorRecord: errorRecord{PluginError: o.Err, DurationMS: o.Err.Duration.Milliseconds()}})
+	default:
+		rec := newPluginRecord(o.Data.Name, o.Data)
+		rec.Path, rec.Cached, rec.DurationMS, rec.Stderr = o.Path, o.Cached, o.Duration.Milliseconds(), o.Data.stderr
+		if !o.Cached && o.Data.attempts > 1 {
+			rec.Attempts = o.Data.attempts
+		}
+		s.write(rec)
+	}
+}
+
+// Changes writes a line per change, as reported by Diff: added plugins as
+// plugin lines, modifications as JSON Patch diffs, removals, and failures
+// as error lines.
+func (s *NDJSONWriter) Changes(changes []Change) {
+	for _, c := range changes {
+		switch c.Kind {
+		case ChangeAdded:
+			s.write(newPluginRecord(c.Name, c.Plugin))
+		case ChangeModified:
+			patch := c.Patch
+			if patch == nil {
+				patch = []PatchOp{}
+			}
+			s.write(diffLine{Type: recordDiff, Name: c.Name, Version: c.Plugin.Version, Patch: patch})
+		case ChangeRemoved:
+			s.write(removedLine{Type: recordRemoved, Name: c.Name})
+		case ChangeFailed:
+			s.Outcome(Outcome{Path: c.Err.Path, Err: c.Err})
+		}
+	}
+}
+
+// Summary writes the summary line for res and returns the first error
+// encountered writing any line.
+func (s *NDJSONWriter) Summary(res *Result) error {
+	s.write(newSummaryLine(res))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *NDJSONWriter) write(v any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == nil {
+		s.err = s.enc.Encode(v)
+	}
+}
+
+func newPluginRecord(name string, p PluginData) pluginRecord {
+	return pluginRecord{Type: recordPlugin, Name: name, Version: p.Version, Metrics: p.Metrics, Data: p.Data}
+}
+
+func newSummaryLine(res *Result) summaryLine {
+	results := res.Paths
+	if results == nil {
+		results = map[string]string{}
+	}
+	return summaryLine{Type: recordSummary, outputMeta: newOutputMeta(res), Results: results, DurationMS: res.Duration.Milliseconds()}
+}
+
+// formatNDJSON renders a finished run in the layout NDJSONWriter streams:
+// plugins in order, then skipped and failed plugins, then the summary.
+func formatNDJSON(res *Result, order []string) ([]byte, error) {
+	var buf bytes.Buffer
+	s := NewNDJSONWriter(&buf)
+	for _, name := range order {
+		rec := newPluginRecord(name, res.Plugins[name])
+		rec.Cached, rec.Attempts, rec.Stderr = contains(res.Cached, name), res.Attempts[name], res.Stderr[name]
+		s.write(rec)
+	}
+	for _, path := range res.Skipped {
+		s.Outcome(Outcome{Path: path, Skipped: true})
+	}
+	for _, e := range res.Errors {
+		s.Outcome(Outcome{Path: e.Path, Err: e})
+	}
+	if err := s.Summary(res); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,54 @@
+package ctxrun
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writePlugin writes an executable shell script named name into dir.
+func writePlugin(t *testing.T, dir, name, script string) string {
+	t.Helper()
+	p := filepath.Join(dir, name)
+	if err := os.WriteFile(p, []byte("#!/bin/sh\n"+script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestRunRejectsUnstreamablePolicies(t *testing.T) {
+	first, second := t.TempDir(), t.TempDir()
+	writePlugin(t, first, "ctx-x", `echo '{"name":"x","version":"1","data":1}'`)
+	writePlugin(t, second, "ctx-x", `echo '{"name":"x","version":"2","data":2}'`)
+	t.Setenv("PATH", second)
+	t.Setenv(PluginPathEnvKey, "")
+
+	tests := []struct {
+		name string
+		opts []Option
+		want string // Substring of the error
+	}{
+		// The shadowed ctx-x in PATH produces a discovery warning.
+		{"budget drop with shadowing", []Option{WithOutputTokenBudget(10), WithBudgetPolicy(BudgetDrop)}, "budget policy"},
+		{"budget fail with unmatched selector", []Option{WithCostBudget(1), WithBudgetPolicy(BudgetFail), WithSelection([]string{"x", "nope"}, nil)}, "budget policy"},
+		{"duplicate error", []Option{WithDuplicatePolicy(DuplicateError)}, "duplicate policy"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var outcomes int
+			opts := append([]Option{WithPluginDirs(first), WithResultHandler(func(Outcome) { outcomes++ })}, tt.opts...)
+			res, err := New(opts...).Run(context.Background())
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Run error = %v, want one mentioning %q", err, tt.want)
+			}
+			if res != nil {
+				t.Errorf("Run result = %#v, want nil", res)
+			}
+			if outcomes != 0 {
+				t.Errorf("result handler called %d times, want 0", outcomes)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package ctxrun
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// pluginJSON renders a plugin's data as JSON for the text formats, indented
+// per opts and keeping the plugin's key order. escapeHTML keeps "<", ">" and
+// "&" escaped so the JSON cannot close surrounding tags.
+func pluginJSON(data json.RawMessage, indent string, escapeHTML bool) string {
+	var buf bytes.Buffer
+	var err error
+	if indent == "" {
+		err = json.Compact(&buf, data)
+	} else {
+		err = json.Indent(&buf, data, "", indent)
+	}
+	if err != nil {
+		return string(data) // Fallback: output as is
+	}
+	if !escapeHTML {
+		return buf.String()
+	}
+	var esc bytes.Buffer
+	json.HTMLEscape(&esc, buf.Bytes())
+	return esc.String()
+}
+
+// formatMarkdown renders res as Markdown: a heading per plugin, in order,
+// with its data in a fenced JSON block, followed by any errors and warnings.
+func formatMarkdown(res *Result, order []string, indent string) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# Context\n\nSession `%s`", res.SessionID)
+	if !res.SessionStart.IsZero() {
+		fmt.Fprintf(&b, " started %s", res.SessionStart.UTC().Format("2006-01-02 15:04:05 MST"))
+	}
+	b.WriteString(".\n")
+	for _, name := range order {
+		p := res.Plugins[name]
+		fmt.Fprintf(&b, "\n## %s", name)
+		if p.Version != "" {
+			fmt.Fprintf(&b, " (%s)", p.Version)
+		}
+		data := pluginJSON(p.Data, indent, false)
+		fence := markdownFence(data)
+		fmt.Fprintf(&b, "\n\n%sjson\n%s\n%s\n", fence, data, fence)
+	}
+	if len(res.Errors) > 0 {
+		b.WriteString("\n## Errors\n\n")
+		for _, e := range res.Errors {
+			fmt.Fprintf(&b, "- `%s` (%s): %s\n", e.Path, e.Kind, oneLine(e.Message))
+		}
+	}
+	if len(res.Warnings) > 0 {
+		b.WriteString("\n## Warnings\n\n")
+		for _, w := range res.Warnings {
+			fmt.Fprintf(&b, "- %s\n", oneLine(w))
+		}
+	}
+	return b.Bytes()
+}
+
+// markdownFence returns a backtick fence longer than any backtick run in s.
+func markdownFence(s string) string {
+	longest, run := 0, 0
+	for _, c := range s {
+		if c == '`' {
+			run++
+			if run > longest {
+				longest = run
+			}
+		} else {
+			run = 0
+		}
+	}
+	if longest < 3 {
+		return "```"
+	}
+	return strings.Repeat("`", longest+1)
+}
+
+// oneLine collapses whitespace so a message fits on a list line.
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// formatPrompt renders res in the document-tag layout recommended for
+// long-context LLM prompts: a <documents> element with one <document> per
+// plugin, in order, carrying its name and version as attributes and its
+// data as JSON. Errors and warnings follow in a <ctx_diagnostics> element.
+func formatPrompt(res *Result, order []string, indent string) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "<documents session_id=\"%s\">\n", xmlAttr(res.SessionID))
+	for i, name := range order {
+		p := res.Plugins[name]
+		fmt.Fprintf(&b, "<document index=\"%d\" name=\"%s\"", i+1, xmlAttr(name))
+		if p.Version != "" {
+			fmt.Fprintf(&b, " version=\"%s\"", xmlAttr(p.Version))
+		}
+		fmt.Fprintf(&b, ">\n<source>ctx plugin %s</source>\n<document_content>\n%s\n</document_content>\n</document>\n", xmlText(name), pluginJSON(p.Data, indent, true))
+	}
+	b.WriteString("</documents>\n")
+	if len(res.Errors) > 0 || len(res.Warnings) > 0 {
+		b.WriteString("<ctx_diagnostics>\n")
+		for _, e := range res.Errors {
+			fmt.Fprintf(&b, "<error path=\"%s\" kind=\"%s\">%s</error>\n", xmlAttr(e.Path), e.Kind, xmlText(oneLine(e.Message)))
+		}
+		for _, w := range res.Warnings {
+			fmt.Fprintf(&b, "<warning>%s</warning>\n", xmlText(oneLine(w)))
+		}
+		b.WriteString("</ctx_diagnostics>\n")
+	}
+	return b.Bytes()
+}
+
+var (
+	xmlTextEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	xmlAttrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;")
+)
+
+func xmlText(s string) string { return xmlTextEscaper.Replace(s) }
+func xmlAttr(s string) string { return xmlAttrEscaper.Replace(s) }
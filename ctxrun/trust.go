@@ -0,0 +1,316 @@
+package ctxrun
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// TrustPolicy controls what happens when a plugin is not in the trust file
+// or its binary does not match the recorded hash.
+type TrustPolicy string
+
+const (
+	TrustOff     TrustPolicy = "off"     // Do not verify plugins
+	TrustWarn    TrustPolicy = "warn"    // Run the plugin but record a warning
+	TrustEnforce TrustPolicy = "enforce" // Refuse to run the plugin
+)
+
+// ParseTrustPolicy parses a TrustPolicy name.
+func ParseTrustPolicy(s string) (TrustPolicy, error) {
+	switch p := TrustPolicy(strings.ToLower(s)); p {
+	case TrustOff, TrustWarn, TrustEnforce:
+		return p, nil
+	}
+	return "", fmt.Errorf("invalid trust policy %q (want off, warn or enforce)", s)
+}
+
+// TrustEntry records the expected SHA256 of a plugin binary and an optional
+// constraint on the version it reports.
+type TrustEntry struct {
+	Name    string `json:"name"`              // Executable name, e.g. ctx-git
+	SHA256  string `json:"sha256"`            // Hex-encoded SHA256 of the binary
+	Version string `json:"version,omitempty"` // e.g. ">=1.2.0, <2"
+}
+
+// TrustFile is the plugin allowlist. A plugin is trusted if any entry with
+// its executable name matches its binary hash.
+type TrustFile struct {
+	Plugins []TrustEntry `json:"plugins"`
+}
+
+// DefaultTrustFilePath returns $XDG_CONFIG_HOME/ctx/trust.yaml, or "" if no
+// configuration directory can be determined.
+func DefaultTrustFilePath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "ctx", "trust.yaml")
+}
+
+// LoadTrustFile reads a trust file. A missing file yields an error
+// satisfying errors.Is(err, fs.ErrNotExist).
+func LoadTrustFile(path string) (*TrustFile, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var tf TrustFile
+	if err := yaml.UnmarshalStrict(b, &tf); err != nil {
+		return nil, fmt.Errorf("failed to parse trust file %s: %w", path, err)
+	}
+	for i, e := range tf.Plugins {
+		if e.Name == "" || e.SHA256 == "" {
+			return nil, fmt.Errorf("trust file %s: entry %d needs both name and sha256", path, i)
+		}
+		if e.Version != "" {
+			if _, err := ParseVersionConstraint(e.Version); err != nil {
+				return nil, fmt.Errorf("trust file %s: entry %q: %w", path, e.Name, err)
+			}
+		}
+	}
+	return &tf, nil
+}
+
+// Save writes the trust file to path, creating parent directories.
+func (t *TrustFile) Save(path string) error {
+	sort.SliceStable(t.Plugins, func(i, j int) bool { return t.Plugins[i].Name < t.Plugins[j].Name })
+	b, err := yaml.Marshal(t)
+	if err != nil {
+		return fmt.Errorf("failed to marshal trust file: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0o644)
+}
+
+// Set records sha256 as the only trusted hash for the named plugin, keeping
+// any existing version constraint.
+func (t *TrustFile) Set(name, sha256 string) {
+	var version string
+	kept := t.Plugins[:0]
+	for _, e := range t.Plugins {
+		if e.Name == name {
+			if version == "" {
+				version = e.Version
+			}
+			continue
+		}
+		kept = append(kept, e)
+	}
+	t.Plugins = append(kept, TrustEntry{Name: name, SHA256: sha256, Version: version})
+}
+
+// lookup returns the entry for name matching sha256, and whether any entry
+// for name exists at all.
+func (t *TrustFile) lookup(name, sha256 string) (entry *TrustEntry, known bool) {
+	for i, e := range t.Plugins {
+		if e.Name != name {
+			continue
+		}
+		known = true
+		if strings.EqualFold(e.SHA256, sha256) {
+			return &t.Plugins[i], true
+		}
+	}
+	return nil, known
+}
+
+// verifyTrust hashes a plugin binary before execution and checks it against
+// the trust file. Under TrustWarn problems are returned as a warning; under
+// TrustEnforce they are returned as a PluginError.
+func (r *Runner) verifyTrust(pluginPath string) (*TrustEntry, string, *PluginError) {
+	if r.trustPolicy == TrustOff || r.trustPolicy == "" {
+		return nil, "", nil
+	}
+	name := filepath.Base(pluginPath)
+	sum, err := HashFile(pluginPath)
+	var problem string
+	var entry *TrustEntry
+	switch {
+	case err != nil:
+		problem = fmt.Sprintf("could not hash binary: %v", err)
+	case r.trust == nil:
+		problem = "no trust file loaded"
+	default:
+		var known bool
+		entry, known = r.trust.lookup(name, sum)
+		if entry == nil && known {
+			problem = fmt.Sprintf("SHA256 mismatch (got %s)", sum)
+		} else if entry == nil {
+			problem = fmt.Sprintf("not in trust file (SHA256 %s)", sum)
+		}
+	}
+	if problem == "" {
+		r.logf("[%s] Trusted (SHA256 %s).", name, sum)
+		return entry, "", nil
+	}
+	warning, perr := r.trustProblem(pluginPath, problem)
+	return nil, warning, perr
+}
+
+// checkTrustedVersion verifies a plugin's reported version against the
+// constraint in its trust entry.
+func (r *Runner) checkTrustedVersion(pluginPath string, entry *TrustEntry, data PluginData) (string, *PluginError) {
+	if entry == nil || entry.Version == "" {
+		return "", nil
+	}
+	ok, err := versionSatisfies(data.Version, entry.Version)
+	if err != nil {
+		return r.trustProblem(pluginPath, fmt.Sprintf("version %q: %v", data.Version, err))
+	}
+	if !ok {
+		return r.trustProblem(pluginPath, fmt.Sprintf("version %q does not satisfy %q", data.Version, entry.Version))
+	}
+	return "", nil
+}
+
+// trustProblem turns a trust failure into a warning or error per policy.
+func (r *Runner) trustProblem(pluginPath, problem string) (string, *PluginError) {
+	name := filepath.Base(pluginPath)
+	if r.trustPolicy == TrustWarn {
+		r.logf("[%s] Warning: untrusted plugin: %s", name, problem)
+		return fmt.Sprintf("untrusted plugin %s: %s", pluginPath, problem), nil
+	}
+	r.logf("[%s] Error: refusing untrusted plugin: %s", name, problem)
+	return "", &PluginError{
+		Path:     pluginPath,
+		Kind:     ErrorKindUntrusted,
+		ExitCode: -1,
+		Message:  problem,
+	}
+}
+
+// A VersionConstraint is a parsed trust entry version constraint.
+type VersionConstraint []versionComparison
+
+// versionComparison is one comparison in a VersionConstraint, such as ">=1.2.0".
+type versionComparison struct {
+	op   string
+	want semver
+}
+
+// ParseVersionConstraint parses a list of comparisons separated by commas or
+// spaces, such as ">=1.2.0, <2". A bare version means equality. Versions are
+// compared numerically by major.minor.patch; a pre-release sorts before its
+// release.
+func ParseVersionConstraint(constraint string) (VersionConstraint, error) {
+	fields := strings.FieldsFunc(constraint, func(r rune) bool { return r == ',' || r == ' ' })
+	if len(fields) == 0 {
+		return nil, errors.New("empty version constraint")
+	}
+	c := make(VersionConstraint, 0, len(fields))
+	for _, f := range fields {
+		op := strings.TrimRight(f, "0123456789.vV-+abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
+		switch op {
+		case "", "=", "==", "!=", ">", ">=", "<", "<=":
+		default:
+			return nil, fmt.Errorf("bad constraint %q: unknown operator %q", f, op)
+		}
+		want, err := parseVersion(f[len(op):])
+		if err != nil {
+			return nil, fmt.Errorf("bad constraint %q: %w", f, err)
+		}
+		c = append(c, versionComparison{op: op, want: want})
+	}
+	return c, nil
+}
+
+// Allows reports whether version satisfies every comparison in c.
+func (c VersionConstraint) Allows(version string) (bool, error) {
+	v, err := parseVersion(version)
+	if err != nil {
+		return false, err
+	}
+	for _, vc := range c {
+		cmp := compareVersions(v, vc.want)
+		var ok bool
+		switch vc.op {
+		case "", "=", "==":
+			ok = cmp == 0
+		case "!=":
+			ok = cmp != 0
+		case ">":
+			ok = cmp > 0
+		case ">=":
+			ok = cmp >= 0
+		case "<":
+			ok = cmp < 0
+		case "<=":
+			ok = cmp <= 0
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// versionSatisfies reports whether version satisfies constraint (see
+// ParseVersionConstraint).
+func versionSatisfies(version, constraint string) (bool, error) {
+	c, err := ParseVersionConstraint(constraint)
+	if err != nil {
+		return false, err
+	}
+	return c.Allows(version)
+}
+
+// semver is a parsed major.minor.patch version with optional pre-release.
+type semver struct {
+	parts [3]int
+	pre   string
+}
+
+func parseVersion(s string) (semver, error) {
+	var v semver
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "v"), "V")
+	if i := strings.IndexByte(s, '+'); i >= 0 {
+		s = s[:i] // Build metadata is ignored
+	}
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		s, v.pre = s[:i], s[i+1:]
+	}
+	nums := strings.Split(s, ".")
+	if len(nums) > 3 || s == "" {
+		return v, fmt.Errorf("invalid version %q", s)
+	}
+	for i, n := range nums {
+		x, err := strconv.Atoi(n)
+		if err != nil || x < 0 {
+			return v, fmt.Errorf("invalid version %q", s)
+		}
+		v.parts[i] = x
+	}
+	return v, nil
+}
+
+func compareVersions(a, b semver) int {
+	for i := range a.parts {
+		if a.parts[i] != b.parts[i] {
+			if a.parts[i] < b.parts[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case a.pre == b.pre:
+		return 0
+	case a.pre == "":
+		return 1
+	case b.pre == "":
+		return -1
+	case a.pre < b.pre:
+		return -1
+	}
+	return 1
+}
@@ -0,0 +1,167 @@
+package ctxrun
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		in   string
+		want semver
+		bad  bool
+	}{
+		{in: "1.2.3", want: semver{parts: [3]int{1, 2, 3}}},
+		{in: "v1.2", want: semver{parts: [3]int{1, 2, 0}}},
+		{in: "V2", want: semver{parts: [3]int{2, 0, 0}}},
+		{in: "1.2.3-rc.1", want: semver{parts: [3]int{1, 2, 3}, pre: "rc.1"}},
+		{in: "1.2.3+build.5", want: semver{parts: [3]int{1, 2, 3}}},
+		{in: "1.2.3-beta+build", want: semver{parts: [3]int{1, 2, 3}, pre: "beta"}},
+		{in: "", bad: true},
+		{in: "v", bad: true},
+		{in: "1.2.3.4", bad: true},
+		{in: "1.x", bad: true},
+		{in: "1..2", bad: true},
+		{in: "-1", bad: true},
+	}
+	for _, tt := range tests {
+		got, err := parseVersion(tt.in)
+		if tt.bad {
+			if err == nil {
+				t.Errorf("parseVersion(%q) = %+v, want error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseVersion(%q) = %+v, %v; want %+v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestVersionSatisfies(t *testing.T) {
+	tests := []struct {
+		version, constraint string
+		want                bool
+	}{
+		{"1.2.0", "1.2.0", true},
+		{"1.2", "=1.2.0", true},
+		{"1.2.1", "==1.2.0", false},
+		{"1.2.1", "!=1.2.0", true},
+		{"1.2.0", ">=1.2.0, <2", true},
+		{"2.0.0", ">=1.2.0, <2", false},
+		{"1.1.9", ">=1.2.0 <2", false},
+		{"1.10.0", ">1.9", true},
+		{"v3.0.0", "<=3", true},
+		{"2.0.0-rc.1", "<2.0.0", true},
+		{"2.0.0-rc.1", ">=2.0.0", false},
+		{"2.0.0-alpha", "<2.0.0-beta", true},
+		{"2.0.0+build", "2.0.0", true},
+	}
+	for _, tt := range tests {
+		got, err := versionSatisfies(tt.version, tt.constraint)
+		if err != nil || got != tt.want {
+			t.Errorf("versionSatisfies(%q, %q) = %v, %v; want %v", tt.version, tt.constraint, got, err, tt.want)
+		}
+	}
+
+	if _, err := versionSatisfies("not-a-version", ">=1"); err == nil {
+		t.Errorf("versionSatisfies with an invalid version succeeded, want error")
+	}
+}
+
+func TestParseVersionConstraintErrors(t *testing.T) {
+	for _, c := range []string{"", " , ", "~1.2", ">=1, ~>2", ">=x", "=>1", "1.2.3.4"} {
+		if _, err := ParseVersionConstraint(c); err == nil {
+			t.Errorf("ParseVersionConstraint(%q) succeeded, want error", c)
+		}
+	}
+}
+
+func TestLoadTrustFileRejectsBadConstraint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trust.yaml")
+	data := "plugins:\n- name: ctx-git\n  sha256: abc\n  version: '>=1, ~2'\n"
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadTrustFile(path); err == nil || !strings.Contains(err.Error(), "ctx-git") {
+		t.Errorf("LoadTrustFile error = %v, want one naming the entry", err)
+	}
+}
+
+func TestVerifyTrust(t *testing.T) {
+	plugin := writePlugin(t, t.TempDir(), "ctx-git", "exit 0\n")
+	sum, err := HashFile(plugin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := strings.Repeat("0", len(sum))
+	tests := []struct {
+		name    string
+		trust   *TrustFile
+		policy  TrustPolicy
+		warning string // Substring of the warning; empty means none
+		perr    string // Substring of the error message; empty means none
+		trusted bool   // Whether an entry is returned
+	}{
+		{"off ignores mismatch", &TrustFile{Plugins: []TrustEntry{{Name: "ctx-git", SHA256: other}}}, TrustOff, "", "", false},
+		{"match", &TrustFile{Plugins: []TrustEntry{{Name: "ctx-git", SHA256: strings.ToUpper(sum)}}}, TrustEnforce, "", "", true},
+		{"match among several", &TrustFile{Plugins: []TrustEntry{{Name: "ctx-git", SHA256: other}, {Name: "ctx-git", SHA256: sum}}}, TrustEnforce, "", "", true},
+		{"mismatch warns", &TrustFile{Plugins: []TrustEntry{{Name: "ctx-git", SHA256: other}}}, TrustWarn, "SHA256 mismatch", "", false},
+		{"mismatch refused", &TrustFile{Plugins: []TrustEntry{{Name: "ctx-git", SHA256: other}}}, TrustEnforce, "", "SHA256 mismatch", false},
+		{"unknown warns", &TrustFile{}, TrustWarn, "not in trust file", "", false},
+		{"unknown refused", &TrustFile{Plugins: []TrustEntry{{Name: "ctx-other", SHA256: sum}}}, TrustEnforce, "", "not in trust file", false},
+		{"no trust file refused", nil, TrustEnforce, "", "no trust file loaded", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := New(WithTrust(tt.trust, tt.policy))
+			entry, warning, perr := r.verifyTrust(plugin)
+			if (entry != nil) != tt.trusted {
+				t.Errorf("entry = %+v, want trusted %v", entry, tt.trusted)
+			}
+			if tt.warning == "" && warning != "" || !strings.Contains(warning, tt.warning) {
+				t.Errorf("warning = %q, want %q", warning, tt.warning)
+			}
+			switch {
+			case tt.perr == "" && perr != nil:
+				t.Errorf("error = %v, want none", perr)
+			case tt.perr != "" && perr == nil:
+				t.Errorf("no error, want %q", tt.perr)
+			case perr != nil && (perr.Kind != ErrorKindUntrusted || !strings.Contains(perr.Message, tt.perr)):
+				t.Errorf("error = %+v, want %s error containing %q", perr, ErrorKindUntrusted, tt.perr)
+			}
+		})
+	}
+}
+
+func TestCheckTrustedVersion(t *testing.T) {
+	entry := &TrustEntry{Name: "ctx-git", SHA256: "abc", Version: ">=1.2, <2"}
+	tests := []struct {
+		version string
+		policy  TrustPolicy
+		ok      bool
+	}{
+		{"1.5.0", TrustEnforce, true},
+		{"2.0.0", TrustEnforce, false},
+		{"2.0.0", TrustWarn, false},
+		{"garbage", TrustEnforce, false},
+	}
+	for _, tt := range tests {
+		r := New(WithTrust(&TrustFile{}, tt.policy))
+		warning, perr := r.checkTrustedVersion("/bin/ctx-git", entry, PluginData{Version: tt.version})
+		if tt.ok {
+			if warning != "" || perr != nil {
+				t.Errorf("version %s: warning %q, error %v; want neither", tt.version, warning, perr)
+			}
+			continue
+		}
+		if tt.policy == TrustWarn && (warning == "" || perr != nil) {
+			t.Errorf("version %s under warn: warning %q, error %v; want only a warning", tt.version, warning, perr)
+		}
+		if tt.policy == TrustEnforce && (warning != "" || perr == nil) {
+			t.Errorf("version %s under enforce: warning %q, error %v; want only an error", tt.version, warning, perr)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package ctxrun
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tmc/ctx/txtar"
+)
+
+// txtar archive layout: the metadata under MetaKey, then for each plugin its
+// data and, if it returned any, its source files.
+const (
+	txtarMetaFile   = MetaKey + ".json"
+	txtarDataFile   = "data.json"  // <plugin>/data.json
+	txtarSourceDir  = "source/"    // <plugin>/source/<file>
+	txtarSourceText = "source.txt" // <plugin>/source.txt: source that was not an archive, or its comment
+)
+
+// formatTxtar renders res as a txtar archive. Source returned by a plugin as
+// a txtar archive is unpacked into the plugin's source directory; marker-like
+// lines in any file are escaped (see package txtar).
+func formatTxtar(res *Result, order []string, meta outputMeta, indent string) ([]byte, error) {
+	var mb []byte
+	var err error
+	if indent == "" {
+		mb, err = json.Marshal(meta)
+	} else {
+		mb, err = json.MarshalIndent(meta, "", indent)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+	a := &txtar.Archive{
+		Comment: []byte(fmt.Sprintf("ctx session %s\n", res.SessionID)),
+		Files:   []txtar.File{{Name: txtarMetaFile, Data: mb}},
+	}
+	for _, name := range order {
+		p := res.Plugins[name]
+		dir := txtarName(name) + "/"
+		a.Files = append(a.Files, txtar.File{Name: dir + txtarDataFile, Data: []byte(pluginJSON(p.Data, indent, false))})
+		if p.Source == "" {
+			continue
+		}
+		src := txtar.Parse([]byte(p.Source))
+		if len(src.Comment) > 0 {
+			a.Files = append(a.Files, txtar.File{Name: dir + txtarSourceText, Data: src.Comment})
+		}
+		for _, f := range src.Files {
+			a.Files = append(a.Files, txtar.File{Name: dir + txtarSourceDir + f.Name, Data: f.Data})
+		}
+	}
+	return txtar.Format(a), nil
+}
+
+// txtarName makes a plugin name usable in a txtar file marker.
+func txtarName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < ' ' || r == 0x7f {
+			return '_'
+		}
+		return r
+	}, name)
+	if strings.TrimSpace(name) == "" {
+		return "_"
+	}
+	return strings.TrimSpace(name)
+}
+
+// ParseTxtar reads back a Result from txtar output written by Format,
+// recovering each plugin's data, version, metrics and source archive, and the
+// session, errors, warnings and other metadata recorded under MetaKey.
+// Budget reports are not recovered.
+func ParseTxtar(data []byte) (*Result, error) {
+	a := txtar.Parse(data)
+	res := &Result{Plugins: map[string]PluginData{}}
+	var meta outputMeta
+	sources := make(map[string]*txtar.Archive)
+	for _, f := range a.Files {
+		if f.Name == txtarMetaFile {
+			if err := json.Unmarshal(f.Data, &meta); err != nil {
+				return nil, fmt.Errorf("parsing %s: %w", txtarMetaFile, err)
+			}
+		}
+	}
+	for _, f := range a.Files {
+		if f.Name == txtarMetaFile {
+			continue
+		}
+		name, file, ok := splitTxtarName(f.Name, meta.Plugins)
+		if !ok {
+			return nil, fmt.Errorf("unexpected file %q in ctx archive", f.Name)
+		}
+		if file == txtarDataFile {
+			if !json.Valid(f.Data) {
+				return nil, fmt.Errorf("plugin %s: %s is not valid JSON", name, txtarDataFile)
+			}
+			p := res.Plugins[name]
+			p.Name, p.Data = name, json.RawMessage(bytes.TrimSpace(f.Data))
+			res.Plugins[name] = p
+			continue
+		}
+		src := sources[name]
+		if src == nil {
+			src = new(txtar.Archive)
+			sources[name] = src
+		}
+		if file == txtarSourceText {
+			src.Comment = f.Data
+		} else {
+			src.Files = append(src.Files, txtar.File{Name: strings.TrimPrefix(file, txtarSourceDir), Data: f.Data})
+		}
+	}
+	for name, src := range sources {
+		p := res.Plugins[name]
+		p.Source = string(txtar.Format(src))
+		res.Plugins[name] = p
+	}
+
+	res.SessionID, res.LogFile = meta.SessionID, meta.LogFile
+	if t, err := time.Parse(time.RFC3339Nano, meta.SessionStart); err == nil {
+		res.SessionStart = t
+	}
+	for name, pm := range meta.Plugins {
+		p, ok := res.Plugins[name]
+		if !ok {
+			continue
+		}
+		p.Version, p.Metrics = pm.Version, pm.Metrics
+		res.Plugins[name] = p
+		if pm.Attempts > 1 {
+			if res.Attempts == nil {
+				res.Attempts = make(map[string]int)
+			}
+			res.Attempts[name] = pm.Attempts
+		}
+		if pm.Stderr != "" {
+			if res.Stderr == nil {
+				res.Stderr = make(map[string]string)
+			}
+			res.Stderr[name] = pm.Stderr
+		}
+		if len(pm.SchemaViolations) > 0 {
+			if res.SchemaViolations == nil {
+				res.SchemaViolations = make(map[string][]string)
+			}
+			res.SchemaViolations[name] = pm.SchemaViolations
+		}
+	}
+	res.Cached, res.Skipped, res.Duplicates, res.Warnings = meta.Cached, meta.Skipped, meta.Duplicates, meta.Warnings
+	for _, e := range meta.Errors {
+		if e.PluginError == nil {
+			continue
+		}
+		e.PluginError.Duration = time.Duration(e.DurationMS) * time.Millisecond
+		res.Errors = append(res.Errors, e.PluginError)
+	}
+	return res, nil
+}
+
+// splitTxtarName splits an archive file name into the plugin name and the
+// file within the plugin's directory. Plugin names may themselves contain
+// slashes (see DuplicateNamespace), so names listed in the metadata are
+// matched first, longest wins.
+func splitTxtarName(name string, plugins map[string]pluginMeta) (plugin, file string, ok bool) {
+	for p := range plugins {
+		if len(p) > len(plugin) && strings.HasPrefix(name, txtarName(p)+"/") {
+			plugin = p
+		}
+	}
+	if plugin != "" {
+		file = name[len(txtarName(plugin))+1:]
+	} else if i := strings.Index(name, "/"); i > 0 {
+		plugin, file = name[:i], name[i+1:]
+	}
+	switch {
+	case plugin == "":
+		return "", "", false
+	case file == txtarDataFile, file == txtarSourceText:
+		return plugin, file, true
+	case strings.HasPrefix(file, txtarSourceDir) && len(file) > len(txtarSourceDir):
+		return plugin, file, true
+	}
+	return "", "", false
+}
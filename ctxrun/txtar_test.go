@@ -0,0 +1,142 @@
+package ctxrun
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTxtarRoundTrip(t *testing.T) {
+	start := time.Date(2025, 1, 2, 3, 4, 5, 6e6, time.UTC)
+	tests := []struct {
+		name string
+		res  *Result
+	}{
+		{"empty", &Result{SessionID: "01JGQ0M5K8ZZZZZZZZZZZZZZZZ", Plugins: map[string]PluginData{}}},
+		{"plugins and metadata", &Result{
+			SessionID:    "01JGQ0M5K8ZZZZZZZZZZZZZZZZ",
+			SessionStart: start,
+			LogFile:      "/tmp/ctx/logs/01JGQ0M5K8ZZZZZZZZZZZZZZZZ.log",
+			Plugins: map[string]PluginData{
+				"git": {Name: "git", Version: "1.0.0", Data: json.RawMessage(`{"branch":"main","dirty":false}`), Metrics: &Metrics{OutputTokenCount: 12}},
+				"env": {Name: "env", Version: "0.1", Data: json.RawMessage(`["-- not a marker --","\\-- x --"]`)},
+			},
+			Attempts:         map[string]int{"git": 2},
+			Stderr:           map[string]string{"env": "warming up\n-- x --\n"},
+			SchemaViolations: map[string][]string{"env": {"/0: expected number, got string"}},
+			Cached:           []string{"env"},
+			Skipped:          []string{"/bin/ctx-skip"},
+			Warnings:         []string{"plugin /a/ctx-git is shadowed by /b/ctx-git"},
+			Errors:           []*PluginError{{Path: "/bin/ctx-bad", Kind: ErrorKindExec, ExitCode: 2, Message: "exit status 2", Stderr: "-- boom --\n", Duration: 15 * time.Millisecond}},
+		}},
+		{"source archives", &Result{
+			SessionID: "01JGQ0M5K8ZZZZZZZZZZZZZZZZ",
+			Plugins: map[string]PluginData{
+				"src": {Name: "src", Version: "1", Data: json.RawMessage(`{}`), Source: "" +
+					"comment line\n" +
+					"-- main.go --\n" +
+					"package main\n" +
+					"\\-- embedded marker --\n" +
+					"\\\\-- escaped twice --\n" +
+					"-- README --\n" +
+					"read me\n"},
+				"plain": {Name: "plain", Version: "1", Data: json.RawMessage(`null`), Source: "-- only.txt --\nx\n"},
+			},
+		}},
+		{"namespaced names", &Result{
+			SessionID: "01JGQ0M5K8ZZZZZZZZZZZZZZZZ",
+			Plugins: map[string]PluginData{
+				"git@/usr/bin/ctx-git":    {Name: "git@/usr/bin/ctx-git", Version: "1", Data: json.RawMessage(`1`)},
+				"git@/home/u/bin/ctx-git": {Name: "git@/home/u/bin/ctx-git", Version: "2", Data: json.RawMessage(`2`), Source: "-- data.json --\nnot the plugin's data\n"},
+			},
+			Duplicates: []DuplicateName{{Name: "git", Paths: []string{"/usr/bin/ctx-git", "/home/u/bin/ctx-git"}, Policy: DuplicateNamespace, Kept: []string{"git@/usr/bin/ctx-git", "git@/home/u/bin/ctx-git"}}},
+		}},
+	}
+	for _, tt := range tests {
+		for _, opts := range []FormatOptions{{Format: "txtar", Indent: 2}, {Format: "txtar", Summary: true}} {
+			t.Run(tt.name, func(t *testing.T) {
+				out, err := Format(tt.res, opts)
+				if err != nil {
+					t.Fatalf("Format: %v", err)
+				}
+				got, err := ParseTxtar([]byte(out))
+				if err != nil {
+					t.Fatalf("ParseTxtar: %v\n%s", err, out)
+				}
+				compactData(t, got)
+				if !reflect.DeepEqual(got, tt.res) {
+					t.Errorf("ParseTxtar(Format(res)) =\n%#v\nwant\n%#v\narchive:\n%s", got, tt.res, out)
+				}
+				again, err := Format(got, opts)
+				if err != nil {
+					t.Fatalf("Format: %v", err)
+				}
+				if again != out {
+					t.Errorf("second Format differs:\n%s\nfirst:\n%s", again, out)
+				}
+			})
+		}
+	}
+}
+
+// compactData removes the indentation Format adds to plugin data.
+func compactData(t *testing.T, res *Result) {
+	t.Helper()
+	for name, p := range res.Plugins {
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, p.Data); err != nil {
+			t.Fatalf("plugin %s: %v", name, err)
+		}
+		p.Data = buf.Bytes()
+		res.Plugins[name] = p
+	}
+}
+
+func TestTxtarSourceWithoutTrailingNewline(t *testing.T) {
+	res := &Result{Plugins: map[string]PluginData{
+		"src": {Name: "src", Version: "1", Data: json.RawMessage(`{}`), Source: "note\n-- a.txt --\n\\-- y --\n-- b.txt --\nlast line"},
+	}}
+	out, err := Format(res, FormatOptions{Format: "txtar"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ParseTxtar([]byte(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The final file gains a newline; the escaped marker stays escaped.
+	want := "note\n-- a.txt --\n\\-- y --\n-- b.txt --\nlast line\n"
+	if src := got.Plugins["src"].Source; src != want {
+		t.Errorf("Source = %q, want %q\narchive:\n%s", src, want, out)
+	}
+}
+
+func TestParseTxtarErrors(t *testing.T) {
+	tests := []struct {
+		name, in string
+	}{
+		{"invalid metadata", "-- _ctx.json --\n{\n"},
+		{"invalid data", "-- _ctx.json --\n{}\n-- git/data.json --\n{\n"},
+		{"unexpected file", "-- _ctx.json --\n{}\n-- git/other.txt --\nx\n"},
+		{"file outside a plugin", "-- _ctx.json --\n{}\n-- stray --\nx\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseTxtar([]byte(tt.in)); err == nil {
+				t.Errorf("ParseTxtar(%q) succeeded, want error", tt.in)
+			}
+		})
+	}
+}
+
+func TestParseTxtarEmptyArchive(t *testing.T) {
+	res, err := ParseTxtar(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.SessionID != "" || len(res.Plugins) != 0 || res.Errors != nil {
+		t.Errorf("ParseTxtar(nil) = %#v, want an empty Result", res)
+	}
+}
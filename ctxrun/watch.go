@@ -0,0 +1,98 @@
+package ctxrun
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// DefaultWatchDebounce is how long Watch waits after a file change for
+// further changes before re-running plugins.
+const DefaultWatchDebounce = 200 * time.Millisecond
+
+// WatchOptions controls what makes Watch re-run plugins. At least one of
+// Interval and Dir must be set.
+type WatchOptions struct {
+	Interval time.Duration // Re-run at this interval; 0 disables
+	Dir      string        // Re-run when files under Dir change; empty disables
+	Poll     time.Duration // Scan interval where file notifications are unavailable; DefaultPollInterval if 0
+	Debounce time.Duration // Quiet period after a file change; DefaultWatchDebounce if 0
+}
+
+// Watch runs the plugins like Run, then re-runs them whenever a trigger in
+// opts fires, until ctx is done. All runs share one session. After the first
+// run fn is called with the result and Diff(nil, res); after later runs it
+// is called only if something changed. Watch returns nil once ctx is done,
+// or the first error from a run or from fn.
+func (r *Runner) Watch(ctx context.Context, opts WatchOptions, fn func(res *Result, changes []Change) error) error {
+	if opts.Interval <= 0 && opts.Dir == "" {
+		return errors.New("watch needs an interval or a directory")
+	}
+	if opts.Debounce <= 0 {
+		opts.Debounce = DefaultWatchDebounce
+	}
+	var files <-chan struct{}
+	if opts.Dir != "" {
+		var err error
+		files, err = r.watchDir(ctx, opts.Dir, opts.Poll)
+		if err != nil {
+			return err
+		}
+	}
+	var tick <-chan time.Time
+	if opts.Interval > 0 {
+		t := time.NewTicker(opts.Interval)
+		defer t.Stop()
+		tick = t.C
+	}
+
+	sessionID, sessionStart := r.sessionID()
+	var prev *Result
+	for {
+		res, err := r.run(ctx, sessionID, sessionStart)
+		if ctx.Err() != nil {
+			return nil // Interrupted; the result is incomplete
+		}
+		if err != nil && !errors.Is(err, ErrBudgetExceeded) {
+			return err
+		}
+		if changes := Diff(prev, res); prev == nil || len(changes) > 0 {
+			if err := fn(res, changes); err != nil {
+				return err
+			}
+		}
+		prev = res
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-tick:
+			r.logf("Watch: interval elapsed, re-running plugins.")
+		case <-files:
+			if !debounce(ctx, files, opts.Debounce) {
+				return nil
+			}
+			r.logf("Watch: files changed under %s, re-running plugins.", opts.Dir)
+		}
+	}
+}
+
+// debounce waits until changes has been quiet for d. It reports false if
+// ctx was done first.
+func debounce(ctx context.Context, changes <-chan struct{}, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-changes:
+			if !t.Stop() {
+				<-t.C
+			}
+			t.Reset(d)
+		case <-t.C:
+			return true
+		}
+	}
+}
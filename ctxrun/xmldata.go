@@ -0,0 +1,133 @@
+package ctxrun
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// XML data modes for FormatOptions.XMLData.
+const (
+	XMLDataStructured = "structured" // Plugin data as nested, typed elements (default)
+	XMLDataJSON       = "json"       // Plugin data as a JSON document in CDATA
+)
+
+// XMLData is a plugin's data in XMLPlugin. In structured form each JSON
+// value becomes an element with a type attribute (object, array, string,
+// number, boolean or null); object members are named after their keys,
+// sanitized into valid XML names with the original kept in a key attribute,
+// and array elements are named item. Otherwise the JSON is embedded as CDATA.
+type XMLData struct {
+	JSON       json.RawMessage
+	Structured bool
+}
+
+// MarshalXML implements xml.Marshaler.
+func (d XMLData) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if !d.Structured {
+		return e.EncodeElement(struct {
+			Text string `xml:",cdata"`
+		}{string(d.JSON)}, start)
+	}
+	if !json.Valid(d.JSON) { // Not JSON after all: keep it as a string
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "type"}, Value: "string"})
+		return e.EncodeElement(string(d.JSON), start)
+	}
+	dec := json.NewDecoder(bytes.NewReader(d.JSON))
+	dec.UseNumber()
+	if err := encodeXMLValue(e, dec, start); err != nil {
+		return fmt.Errorf("converting plugin data to XML: %w", err)
+	}
+	return nil
+}
+
+// encodeXMLValue reads one JSON value from dec and writes it as the element
+// start.
+func encodeXMLValue(e *xml.Encoder, dec *json.Decoder, start xml.StartElement) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	typ, text := "", ""
+	switch v := tok.(type) {
+	case json.Delim:
+		typ = "object"
+		if v == '[' {
+			typ = "array"
+		}
+	case string:
+		typ, text = "string", v
+	case json.Number:
+		typ, text = "number", v.String()
+	case bool:
+		typ, text = "boolean", fmt.Sprint(v)
+	case nil:
+		typ = "null"
+	}
+	start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "type"}, Value: typ})
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	switch typ {
+	case "object":
+		for dec.More() {
+			keyTok, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			key, _ := keyTok.(string)
+			child := xml.StartElement{Name: xml.Name{Local: xmlName(key)}}
+			if child.Name.Local != key {
+				child.Attr = []xml.Attr{{Name: xml.Name{Local: "key"}, Value: key}}
+			}
+			if err := encodeXMLValue(e, dec, child); err != nil {
+				return err
+			}
+		}
+		if _, err := dec.Token(); err != nil { // Closing brace
+			return err
+		}
+	case "array":
+		for dec.More() {
+			if err := encodeXMLValue(e, dec, xml.StartElement{Name: xml.Name{Local: "item"}}); err != nil {
+				return err
+			}
+		}
+		if _, err := dec.Token(); err != nil { // Closing bracket
+			return err
+		}
+	default:
+		if text != "" {
+			if err := e.EncodeToken(xml.CharData(text)); err != nil {
+				return err
+			}
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+// xmlName turns a JSON object key into a valid XML element name: characters
+// not allowed in names become "_", and names that are empty, start with a
+// character other than a letter or "_", or start with the reserved "xml"
+// prefix get a leading "_".
+func xmlName(key string) string {
+	var b strings.Builder
+	for _, r := range key {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-' || r == '.' {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('_')
+		}
+	}
+	name := b.String()
+	if name == "" || strings.HasPrefix(strings.ToLower(name), "xml") {
+		return "_" + name
+	}
+	if first := []rune(name)[0]; !unicode.IsLetter(first) && first != '_' {
+		return "_" + name
+	}
+	return name
+}
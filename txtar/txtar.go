@@ -0,0 +1,141 @@
+// Package txtar reads and writes the txtar archives ctx uses to carry
+// plugin data and source files as plain text.
+//
+// An archive is an optional comment followed by files, each introduced by a
+// marker line of the form
+//
+//	-- name --
+//
+// Lines in file content that would read as a marker are escaped by
+// prefixing a backslash ("\-- name --"); lines already escaped that way get
+// one more backslash, so Parse(Format(a)) returns a's files unchanged, except
+// that non-empty content always ends in a newline.
+package txtar
+
+import (
+	"bytes"
+	"strings"
+)
+
+// An Archive is a collection of files.
+type Archive struct {
+	Comment []byte
+	Files   []File
+}
+
+// A File is a single file in an archive.
+type File struct {
+	Name string // Name of file ("foo/bar.txt")
+	Data []byte // Text content of file
+}
+
+// Format returns the serialized form of a, escaping marker-like lines in
+// the comment and file contents.
+func Format(a *Archive) []byte {
+	var buf bytes.Buffer
+	buf.Write(fixNL(Escape(a.Comment)))
+	for _, f := range a.Files {
+		buf.WriteString("-- " + f.Name + " --\n")
+		buf.Write(fixNL(Escape(f.Data)))
+	}
+	return buf.Bytes()
+}
+
+// Parse parses the serialized form of an archive, unescaping the comment
+// and file contents. Parse never fails: text before the first marker is the
+// comment, and a file runs until the next marker or the end of data.
+func Parse(data []byte) *Archive {
+	a := new(Archive)
+	var name string
+	a.Comment, name, data = findMarker(data)
+	a.Comment = Unescape(a.Comment)
+	for name != "" {
+		f := File{Name: name}
+		f.Data, name, data = findMarker(data)
+		f.Data = Unescape(f.Data)
+		a.Files = append(a.Files, f)
+	}
+	return a
+}
+
+// Escape prefixes every line of data that is a marker, or an escaped
+// marker, with a backslash.
+func Escape(data []byte) []byte {
+	return mapLines(data, func(line []byte) []byte {
+		if isMarker(bytes.TrimLeft(line, `\`)) {
+			return append([]byte{'\\'}, line...)
+		}
+		return line
+	})
+}
+
+// Unescape reverses Escape, removing one backslash from lines that are
+// escaped markers.
+func Unescape(data []byte) []byte {
+	return mapLines(data, func(line []byte) []byte {
+		if len(line) > 0 && line[0] == '\\' && isMarker(bytes.TrimLeft(line, `\`)) {
+			return line[1:]
+		}
+		return line
+	})
+}
+
+// findMarker finds the next marker line in data, returning the text before
+// it, the marker's file name, and the text after it. If there is no marker,
+// it returns data, "", nil.
+func findMarker(data []byte) (before []byte, name string, after []byte) {
+	var i int
+	for {
+		if name, after = markerName(data[i:]); name != "" {
+			return data[:i], name, after
+		}
+		j := bytes.IndexByte(data[i:], '\n')
+		if j < 0 {
+			return data, "", nil
+		}
+		i += j + 1
+	}
+}
+
+// markerName reports the file name if data begins with a marker line, and
+// the text after it.
+func markerName(data []byte) (string, []byte) {
+	line, after, _ := bytes.Cut(data, []byte("\n"))
+	if !isMarker(line) {
+		return "", nil
+	}
+	line = bytes.TrimSuffix(line, []byte("\r"))
+	return strings.TrimSpace(string(line[3 : len(line)-3])), after
+}
+
+// isMarker reports whether line (without its newline) is "-- name --".
+func isMarker(line []byte) bool {
+	line = bytes.TrimSuffix(line, []byte("\r"))
+	return len(line) >= 7 && bytes.HasPrefix(line, []byte("-- ")) && bytes.HasSuffix(line, []byte(" --")) &&
+		len(bytes.TrimSpace(line[3:len(line)-3])) > 0
+}
+
+// mapLines applies fn to each line of data, without its newline.
+func mapLines(data []byte, fn func([]byte) []byte) []byte {
+	if len(data) == 0 {
+		return data
+	}
+	var out bytes.Buffer
+	for len(data) > 0 {
+		line, rest, found := bytes.Cut(data, []byte("\n"))
+		out.Write(fn(line))
+		if found {
+			out.WriteByte('\n')
+		}
+		data = rest
+	}
+	return out.Bytes()
+}
+
+// fixNL returns data with a trailing newline added if it is missing.
+func fixNL(data []byte) []byte {
+	if len(data) == 0 || data[len(data)-1] == '\n' {
+		return data
+	}
+	return append(data, '\n')
+}
@@ -0,0 +1,135 @@
+package txtar
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		a    *Archive
+	}{
+		{"empty archive", &Archive{}},
+		{"comment only", &Archive{Comment: []byte("just a comment\n")}},
+		{"plain files", &Archive{
+			Comment: []byte("comment\n"),
+			Files: []File{
+				{Name: "a.txt", Data: []byte("hello\n")},
+				{Name: "dir/b.txt", Data: []byte("one\ntwo\n")},
+			},
+		}},
+		{"empty file", &Archive{Files: []File{{Name: "empty"}, {Name: "next", Data: []byte("x\n")}}}},
+		{"marker in data", &Archive{Files: []File{{Name: "a", Data: []byte("before\n-- x --\nafter\n")}}}},
+		{"marker as first and last line", &Archive{Files: []File{{Name: "a", Data: []byte("-- x --\nmiddle\n-- y --\n")}}}},
+		{"escaped marker in data", &Archive{Files: []File{{Name: "a", Data: []byte(`\-- x --` + "\n")}}}},
+		{"doubly escaped marker", &Archive{Files: []File{{Name: "a", Data: []byte(`\\-- x --` + "\n" + `\-- y --` + "\n-- z --\n")}}}},
+		{"marker in comment", &Archive{Comment: []byte("-- not a file --\n"), Files: []File{{Name: "a", Data: []byte("x\n")}}}},
+		{"CRLF marker in data", &Archive{Files: []File{{Name: "a", Data: []byte("-- x --\r\n")}}}},
+		{"marker-like lines that are not markers", &Archive{Files: []File{{Name: "a", Data: []byte("-- --\n--x--\n \\-- x --\n-- x\n")}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Parse(Format(tt.a))
+			if !equal(got, tt.a) {
+				t.Errorf("Parse(Format(a)) =\n%s\nwant\n%s", dump(got), dump(tt.a))
+			}
+		})
+	}
+}
+
+func TestMissingTrailingNewline(t *testing.T) {
+	a := &Archive{
+		Comment: []byte("comment"),
+		Files: []File{
+			{Name: "a", Data: []byte("no newline")},
+			{Name: "b", Data: []byte("-- x --")},
+		},
+	}
+	want := "comment\n-- a --\nno newline\n-- b --\n\\-- x --\n"
+	if got := string(Format(a)); got != want {
+		t.Fatalf("Format =\n%q\nwant\n%q", got, want)
+	}
+	got := Parse([]byte(want))
+	wantArchive := &Archive{
+		Comment: []byte("comment\n"),
+		Files: []File{
+			{Name: "a", Data: []byte("no newline\n")},
+			{Name: "b", Data: []byte("-- x --\n")},
+		},
+	}
+	if !equal(got, wantArchive) {
+		t.Errorf("Parse =\n%s\nwant\n%s", dump(got), dump(wantArchive))
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want *Archive
+	}{
+		{"empty", "", &Archive{}},
+		{"no markers", "text\n", &Archive{Comment: []byte("text\n")}},
+		{"last file without newline", "-- a --\nx", &Archive{Files: []File{{Name: "a", Data: []byte("x")}}}},
+		{"marker without newline", "-- a --", &Archive{Files: []File{{Name: "a"}}}},
+		{"name trimmed", "--  a b  --\nx\n", &Archive{Files: []File{{Name: "a b", Data: []byte("x\n")}}}},
+		{"CRLF markers", "-- a --\r\nx\r\n", &Archive{Files: []File{{Name: "a", Data: []byte("x\r\n")}}}},
+		{"escaped marker", "-- a --\n\\-- b --\n", &Archive{Files: []File{{Name: "a", Data: []byte("-- b --\n")}}}},
+		{"backslash on other lines kept", "-- a --\n\\x\n", &Archive{Files: []File{{Name: "a", Data: []byte("\\x\n")}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Parse([]byte(tt.in)); !equal(got, tt.want) {
+				t.Errorf("Parse(%q) =\n%s\nwant\n%s", tt.in, dump(got), dump(tt.want))
+			}
+		})
+	}
+}
+
+func TestEscape(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"", ""},
+		{"plain\n", "plain\n"},
+		{"-- a --\n", "\\-- a --\n"},
+		{"\\-- a --\n", "\\\\-- a --\n"},
+		{"\\\\-- a --", "\\\\\\-- a --"},
+		{"x\n-- a --\ny", "x\n\\-- a --\ny"},
+		{"-- --\n", "-- --\n"},
+		{"\\x\n", "\\x\n"},
+	}
+	for _, tt := range tests {
+		got := Escape([]byte(tt.in))
+		if string(got) != tt.want {
+			t.Errorf("Escape(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+		if back := Unescape(got); string(back) != tt.in {
+			t.Errorf("Unescape(Escape(%q)) = %q", tt.in, back)
+		}
+	}
+}
+
+// equal compares archives, treating nil and empty content alike.
+func equal(a, b *Archive) bool {
+	if !bytes.Equal(a.Comment, b.Comment) || len(a.Files) != len(b.Files) {
+		return false
+	}
+	for i := range a.Files {
+		if a.Files[i].Name != b.Files[i].Name || !bytes.Equal(a.Files[i].Data, b.Files[i].Data) {
+			return false
+		}
+	}
+	return true
+}
+
+// dump describes an archive without escaping, for failure messages.
+func dump(a *Archive) string {
+	s := fmt.Sprintf("comment %q", a.Comment)
+	for _, f := range a.Files {
+		s += fmt.Sprintf("\nfile %q: %q", f.Name, f.Data)
+	}
+	return s
+}